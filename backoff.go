@@ -1,40 +1,345 @@
 package recovery
 
 import (
+	"context"
 	"fmt"
 	"math"
-	"math/rand"
 	"os"
 	"time"
-
-	"github.com/yabosh/logger"
 )
 
 // BackoffS will pause (sleep) for a period of time determined by an exponential backoff algorithm.
 // attempt is the number of unsuccessful attempts to perform a task that have occurred.  The
 // algorithm uses the number of attempts to determine the length of time to pause.
 func BackoffS(attempt int) {
-	time.Sleep(time.Duration(GetNextBackOffMilliseconds(attempt)) * time.Millisecond)
+	currentClock().Sleep(ceilBackoff(NextBackoff(attempt)))
+}
+
+// MaxBackoffCeiling, when greater than zero, caps every pause made by the
+// package, whatever maximum was passed to an individual call or option.  It is a
+// guardrail against a misconfigured maximum making a worker sleep for hours, and
+// applies to the Backoff functions as well as to the WithRestart and
+// UntilSuccessful families.  Functions that only compute a delay, such as
+// ExponentialBackoffMS, are not affected.  The default of 0 leaves pauses
+// unlimited.  It is typically set once during application startup.
+var MaxBackoffCeiling time.Duration
+
+// ceilBackoff limits d to MaxBackoffCeiling.
+func ceilBackoff(d time.Duration) time.Duration {
+	if MaxBackoffCeiling > 0 && d > MaxBackoffCeiling {
+		return MaxBackoffCeiling
+	}
+	return d
+}
+
+// NextBackoff returns the time BackoffS would pause for after attempt unsuccessful
+// attempts, without sleeping.  It uses the same defaults as
+// GetNextBackOffMilliseconds and is useful for scheduling a retry rather than
+// blocking until it is due.
+func NextBackoff(attempt int) time.Duration {
+	return time.Duration(GetNextBackOffMilliseconds(attempt)) * time.Millisecond
 }
 
 // Backoff will pause the current goroutine for a period of time
 // using an exponential backoff algorithm.
-func Backoff(attempts int, jitterMS int, maxMS int) {
-	backoff := ExponentialBackoffMS(attempts, jitterMS, maxMS)
-	time.Sleep(time.Duration(backoff) * time.Millisecond)
+//
+// Pass WithFullJitter() to use FullJitterBackoffMS instead of adding jitterMS
+// of randomness to the exponential value, and WithMinBackoff() to clamp the pause
+// to [minMS, maxMS].  A hook set with WithOnBackoff is called with the pause
+// just before sleeping.
+//
+// Backoff is a thin wrapper that calls BackoffContext with context.Background().
+func Backoff(attempts int, jitterMS int, maxMS int, opts ...Option) {
+	BackoffContext(context.Background(), attempts, jitterMS, maxMS, opts...)
+}
+
+// backoffFor returns the pause Backoff applies after attempts unsuccessful
+// attempts.
+func (c *config) backoffFor(attempts int, jitterMS int, maxMS int) time.Duration {
+	var backoff int
+	if c.fullJitter {
+		backoff = FullJitterBackoffMS(attempts, 1000, maxMS)
+	} else {
+		backoff = ExponentialBackoffMS(attempts, jitterMS, maxMS)
+	}
+	return ceilBackoff(time.Duration(ClampBackoffMS(backoff, c.minBackoffMS, maxMS)) * time.Millisecond)
+}
+
+// BackoffContext pauses like Backoff but returns early with ctx.Err() if ctx is
+// cancelled before the backoff period has elapsed.  It returns nil once the full
+// period has passed.  opts are applied as they are by Backoff.
+//
+// If ctx has a deadline then the pause never extends past it.  When the deadline
+// has already passed BackoffContext returns context.DeadlineExceeded immediately,
+// and when the deadline falls within the backoff period BackoffContext sleeps only
+// until the deadline and then returns context.DeadlineExceeded, since there is no
+// time left for another attempt.  A ctx without a deadline is only interrupted by
+// cancellation.
+func BackoffContext(ctx context.Context, attempts int, jitterMS int, maxMS int, opts ...Option) error {
+	cfg := newConfig(opts)
+	backoff := cfg.backoffFor(attempts, jitterMS, maxMS)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		cfg.backingOff("", attempts, backoff)
+		return sleepContext(ctx, backoff)
+	}
+
+	remaining := deadline.Sub(currentClock().Now())
+	if remaining <= 0 {
+		return context.DeadlineExceeded
+	}
+	if backoff < remaining {
+		cfg.backingOff("", attempts, backoff)
+		return sleepContext(ctx, backoff)
+	}
+
+	cfg.backingOff("", attempts, remaining)
+	if err := sleepContext(ctx, remaining); err != nil {
+		return err
+	}
+	return context.DeadlineExceeded
+}
+
+// BackoffUntil pauses like BackoffContext but never sleeps past deadline: if the
+// computed backoff would extend beyond deadline it is shortened to end at the
+// deadline.  If deadline has already passed BackoffUntil returns
+// context.DeadlineExceeded immediately.  Cancelling ctx wakes it early with
+// ctx.Err().
+func BackoffUntil(ctx context.Context, attempt int, jitterMS int, maxMS int, deadline time.Time) error {
+	remaining := deadline.Sub(currentClock().Now())
+	if remaining <= 0 {
+		return context.DeadlineExceeded
+	}
+
+	backoff := ceilBackoff(time.Duration(ExponentialBackoffMS(attempt, jitterMS, maxMS)) * time.Millisecond)
+	if backoff > remaining {
+		backoff = remaining
+	}
+	return sleepContext(ctx, backoff)
+}
+
+// sleepContext pauses for d or until ctx is cancelled, whichever comes first.  It
+// is used for every interruptible pause in the package.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-currentClock().After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ExponentialBackoffMS returns the number of milliseconds to wait before
 // retrying an operation using an exponential formula.
 //
 // attempts is the number of times in a row an operation has been attempted and failed.
-// jitterMS is the number of milliseconds of 'jitter' (randomness) to inject into the formula
+// jitterMS is the number of milliseconds of 'jitter' (randomness) to inject into the formula, 0 for none
 // maxMS is the maximum time returned (in milliseconds)
 func ExponentialBackoffMS(attempts int, jitterMS int, maxMS int) int {
+	return int(NewExponential(jitterMS, maxMS).Duration(attempts) / time.Millisecond)
+}
+
+// ExponentialBackoffBase is ExponentialBackoffMS with a configurable base delay: it
+// returns min(2^attempt * baseMS + random(0, jitterMS), maxMS).  A small baseMS
+// suits sub-second RPC retries and a large one suits slow polling.
+// ExponentialBackoffMS uses a baseMS of 1000.
+func ExponentialBackoffBase(attempt int, baseMS int, jitterMS int, maxMS int) int {
+	strategy := exponential{baseMS: baseMS, jitterMS: jitterMS, maxMS: maxMS}
+	return int(strategy.Duration(attempt) / time.Millisecond)
+}
+
+// DeterministicBackoffMS returns the exponential component of ExponentialBackoffMS,
+// min(2^attempt * 1000, maxMS), with no random jitter.  It is useful for asserting
+// exact values against the growth curve.
+func DeterministicBackoffMS(attempt int, maxMS int) int {
+	return exponentialDelayMS(attempt, defaultBaseMS, 0, maxMS)
+}
+
+// FullJitterBackoffMS returns a random number of milliseconds between 0 and
+// min(maxMS, baseMS * 2^attempt) inclusive.  This is the "full jitter" algorithm
+// which spreads retries from many clients evenly over the backoff window rather
+// than clustering them around the exponential value.
+func FullJitterBackoffMS(attempt int, baseMS int, maxMS int) int {
+	return ExponentialBackoffWithJitter(attempt, baseMS, maxMS, JitterFull)
+}
+
+// JitterMode selects how randomness is applied by ExponentialBackoffWithJitter.
+type JitterMode int
+
+const (
+	// JitterNone uses the exponential value unchanged.
+	JitterNone JitterMode = iota
+
+	// JitterFull chooses a value at random between 0 and the exponential value.
+	JitterFull
+
+	// JitterEqual keeps half of the exponential value and chooses the other half
+	// at random.
+	JitterEqual
+)
+
+// ExponentialBackoffWithJitter returns the number of milliseconds to wait before
+// retrying an operation.  The exponential value min(maxMS, baseMS * 2^attempt) is
+// adjusted according to mode:
+//
+//   - JitterNone returns the exponential value.  It is predictable but clients
+//     that fail together retry together, which can produce retry storms.
+//   - JitterFull returns random(0, value).  It spreads retries the most and
+//     usually completes the most work under contention, but an individual
+//     retry may happen almost immediately.
+//   - JitterEqual returns value/2 + random(0, value/2).  It guarantees at least
+//     half of the exponential delay while still spreading retries out.
+func ExponentialBackoffWithJitter(attempt int, baseMS int, maxMS int, mode JitterMode) int {
+	value := cappedExponentialMS(attempt, baseMS, maxMS)
+	if value <= 0 {
+		return 0
+	}
+
+	switch mode {
+	case JitterFull:
+		return randIntn(value + 1)
+	case JitterEqual:
+		half := value / 2
+		return half + randIntn(value-half+1)
+	}
+	return value
+}
 
-	randomMs := float64(rand.Intn(jitterMS))
-	return int(math.Min((math.Pow(2, float64(attempts))*1000 + randomMs), float64(maxMS)))
+// ExponentialBackoffPct returns the number of milliseconds to wait before retrying
+// an operation where the jitter is proportional to the delay.  The exponential
+// value baseMS * 2^attempt is randomly adjusted by up to ±jitterPct of itself, so
+// a jitterPct of 0.2 produces a delay within 20% either side of the exponential
+// value.  The result is clamped to [0, maxMS].
+func ExponentialBackoffPct(attempt int, baseMS int, maxMS int, jitterPct float64) int {
+	value := float64(baseMS) * math.Pow(2, float64(clampExponent(attempt)))
+	if jitterPct > 0 {
+		value += value * jitterPct * (2*randFloat64() - 1)
+	}
+	return int(math.Max(0, math.Min(value, float64(maxMS))))
+}
+
+// ClampBackoffMS limits backoffMS to the range [minMS, maxMS].  If minMS is
+// greater than maxMS then maxMS wins.
+func ClampBackoffMS(backoffMS int, minMS int, maxMS int) int {
+	if backoffMS < minMS {
+		backoffMS = minMS
+	}
+	if backoffMS > maxMS {
+		backoffMS = maxMS
+	}
+	return backoffMS
+}
+
+// cappedExponentialMS returns min(maxMS, baseMS * 2^attempt) without any jitter.
+func cappedExponentialMS(attempt int, baseMS int, maxMS int) int {
+	return exponentialDelayMS(attempt, baseMS, 0, maxMS)
+}
+
+// LinearBackoff will pause the current goroutine for a period of time that
+// grows by stepMS milliseconds with each attempt, up to maxMS milliseconds.
+func LinearBackoff(attempt int, stepMS int, maxMS int) {
+	currentClock().Sleep(ceilBackoff(time.Duration(LinearBackoffMS(attempt, stepMS, maxMS)) * time.Millisecond))
+}
+
+// LinearBackoffMS returns the number of milliseconds to wait before retrying an
+// operation using a linear formula: min(attempt * stepMS, maxMS).
+func LinearBackoffMS(attempt int, stepMS int, maxMS int) int {
+	return int(NewLinear(stepMS, maxMS).Duration(attempt) / time.Millisecond)
+}
+
+// ConstantBackoff will pause the current goroutine for intervalMS milliseconds
+// plus up to jitterMS milliseconds of randomness, regardless of the attempt.  It
+// suits polling, where a steady interval is wanted but workers should not poll in
+// lockstep.
+func ConstantBackoff(intervalMS int, jitterMS int) {
+	currentClock().Sleep(ceilBackoff(time.Duration(ConstantBackoffMS(intervalMS, jitterMS)) * time.Millisecond))
+}
+
+// ConstantBackoffMS returns the number of milliseconds ConstantBackoff pauses for:
+// intervalMS plus a random amount in [0, jitterMS).  A jitterMS of 0 or less
+// disables the random component.
+func ConstantBackoffMS(intervalMS int, jitterMS int) int {
+	return int(constant{intervalMS: intervalMS, jitterMS: jitterMS}.Duration(0) / time.Millisecond)
+}
+
+// FibonacciBackoffMS returns the number of milliseconds to wait before retrying
+// an operation where the delay follows the Fibonacci sequence, which grows more
+// gently than an exponential curve.  Attempts 0, 1, 2, 3, 4, ... produce delays of
+// 1, 1, 2, 3, 5, ... multiplied by unitMS.  The result never exceeds maxMS.
+func FibonacciBackoffMS(attempt int, unitMS int, maxMS int) int {
+	if unitMS <= 0 {
+		return 0
+	}
+
+	limit := maxMS / unitMS
+	prev, cur := 0, 1
+	for i := 0; i < attempt; i++ {
+		if cur > limit {
+			// Stop before the sequence can overflow; the result is capped anyway.
+			break
+		}
+		prev, cur = cur, prev+cur
+	}
+
+	if cur > limit {
+		return maxMS
+	}
+	return cur * unitMS
+}
+
+// BackoffSchedule returns the pauses ExponentialBackoffMS produces for the first
+// attempts attempts with the random jitter removed, making the schedule
+// deterministic.  Each actual pause is at most jitterMS milliseconds longer than
+// the corresponding entry, but never longer than maxMS.
+func BackoffSchedule(attempts int, jitterMS int, maxMS int) []time.Duration {
+	if attempts < 0 {
+		attempts = 0
+	}
+
+	schedule := make([]time.Duration, 0, attempts)
+	for attempt := 0; attempt < attempts; attempt++ {
+		schedule = append(schedule, time.Duration(DeterministicBackoffMS(attempt, maxMS))*time.Millisecond)
+	}
+	return schedule
+}
+
+// MaxTotalBackoff returns the longest total time that a bounded retry using the
+// default exponential backoff with jitterMS and maxMS can spend sleeping, for
+// example Retry with WithJitter(jitterMS) and WithMaxBackoff(maxMS).  maxAttempts
+// attempts are separated by maxAttempts-1 pauses, and each pause is counted with
+// the largest jitter the helpers can add to it and limited by MaxBackoffCeiling.
+// The time taken by the attempts themselves is not included.
+//
+// It is intended for checking a retry configuration against an upstream timeout
+// before deploying it.
+//
+// A maxAttempts of 0 or less means that the helpers retry indefinitely, so there
+// is no worst case; MaxTotalBackoff then returns the largest representable
+// duration, which exceeds any timeout it is compared with.
+func MaxTotalBackoff(maxAttempts int, jitterMS int, maxMS int) time.Duration {
+	if maxAttempts <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	// The jitter is chosen from [0, jitterMS).
+	var worstJitter float64
+	if jitterMS > 0 {
+		worstJitter = float64(jitterMS - 1)
+	}
+
+	var total time.Duration
+	for attempt := 0; attempt < maxAttempts-1; attempt++ {
+		total += ceilBackoff(time.Duration(exponentialDelayMS(attempt, defaultBaseMS, worstJitter, maxMS)) * time.Millisecond)
+	}
+	return total
+}
 
+// ExponentialBackoff is equivalent to ExponentialBackoffMS but takes and returns
+// time.Duration values so that the units are unambiguous.  jitter and maxBackoff are
+// used with millisecond resolution.
+func ExponentialBackoff(attempt int, jitter time.Duration, maxBackoff time.Duration) time.Duration {
+	return NewExponential(int(jitter/time.Millisecond), int(maxBackoff/time.Millisecond)).Duration(attempt)
 }
 
 // GetNextBackOffMilliseconds calculates an exponential value used for 'exponential backoff' scenarios.
@@ -42,10 +347,49 @@ func GetNextBackOffMilliseconds(attempts int) int {
 	return ExponentialBackoffMS(attempts, 5000, 64000)
 }
 
+// GetNextBackOffEqualJitter is an "equal jitter" alternative to
+// GetNextBackOffMilliseconds with the same 64 second maximum.  It returns half of
+// the exponential value plus a random amount up to the other half, rather than
+// adding up to 5 seconds of jitter, which is a large share of the delay for the
+// first few attempts.
+func GetNextBackOffEqualJitter(attempt int) int {
+	return ExponentialBackoffWithJitter(attempt, defaultBaseMS, 64000, JitterEqual)
+}
+
+// FailExitCode is the exit code used by FatalOnError and FailOnError when they
+// terminate the process.
+var FailExitCode = 10
+
+// ExitFunc is called by FatalOnError to terminate the process.  It defaults to
+// os.Exit and may be replaced, typically in tests, to observe the exit code
+// instead of exiting.
+var ExitFunc = os.Exit
+
+// FatalOnError logs msg, formatted using a, followed by err and calls ExitFunc
+// with FailExitCode if err is not nil.  With the default ExitFunc deferred
+// functions in the caller are not run.  If ExitFunc has been replaced by one that
+// returns then FatalOnError returns too.
+func FatalOnError(err error, msg string, a ...interface{}) {
+	if err != nil {
+		args := append(append([]interface{}{}, a...), err)
+		newConfig(nil).logError("", 0, err, msg+": %s", args...)
+		ExitFunc(FailExitCode)
+	}
+}
+
+// FailOnError is an alias for FatalOnError, kept for compatibility.
 func FailOnError(err error, msg string, a ...interface{}) {
+	FatalOnError(err, msg, a...)
+}
+
+// MustSucceed panics if err is not nil.  The panic value is an error that wraps err
+// and is prefixed with msg formatted using a.
+//
+// Unlike FailOnError, MustSucceed allows deferred functions to run and the panic to
+// be recovered, for example by DontPanic, which makes it preferable in library code
+// and testable.
+func MustSucceed(err error, msg string, a ...interface{}) {
 	if err != nil {
-		fmtString := fmt.Sprintf("%s: %s", msg, err)
-		logger.Error(fmtString, a)
-		os.Exit(10)
+		panic(fmt.Errorf("%s: %w", fmt.Sprintf(msg, a...), err))
 	}
 }
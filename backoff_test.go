@@ -0,0 +1,306 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFullJitterBackoffMSBounds(t *testing.T) {
+	const maxMS = 64000
+	for attempt := 0; attempt < 100; attempt++ {
+		for i := 0; i < 100; i++ {
+			got := FullJitterBackoffMS(attempt, 1000, maxMS)
+			if got < 0 || got > maxMS {
+				t.Fatalf("FullJitterBackoffMS(%d, 1000, %d) = %d, want within [0, %d]", attempt, maxMS, got, maxMS)
+			}
+		}
+	}
+}
+
+func TestLinearBackoffMS(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    int
+	}{
+		{0, 0},
+		{1, 250},
+		{4, 1000},
+		{5, 1000}, // capped
+		{100, 1000},
+	}
+	for _, tt := range tests {
+		if got := LinearBackoffMS(tt.attempt, 250, 1000); got != tt.want {
+			t.Errorf("LinearBackoffMS(%d, 250, 1000) = %d, want %d", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestFibonacciBackoffMS(t *testing.T) {
+	want := []int{1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+	for attempt, fib := range want {
+		if got := FibonacciBackoffMS(attempt, 100, 64000); got != fib*100 {
+			t.Errorf("FibonacciBackoffMS(%d, 100, 64000) = %d, want %d", attempt, got, fib*100)
+		}
+	}
+
+	if got := FibonacciBackoffMS(10, 100, 5000); got != 5000 {
+		t.Errorf("FibonacciBackoffMS(10, 100, 5000) = %d, want the cap of 5000", got)
+	}
+}
+
+func TestExponentialBackoffMSWithoutJitter(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		if got := ExponentialBackoffMS(3, 0, 64000); got != 8000 {
+			t.Fatalf("ExponentialBackoffMS(3, 0, 64000) = %d, want 8000", got)
+		}
+	}
+}
+
+func TestExponentialBackoffMSHighAttempts(t *testing.T) {
+	if got := ExponentialBackoffMS(1000, 100, 64000); got != 64000 {
+		t.Errorf("ExponentialBackoffMS(1000, 100, 64000) = %d, want 64000", got)
+	}
+}
+
+func TestFatalOnErrorLogsAndExits(t *testing.T) {
+	for name, fail := range map[string]func(error, string, ...interface{}){
+		"FatalOnError": FatalOnError,
+		"FailOnError":  FailOnError,
+	} {
+		t.Run(name, func(t *testing.T) {
+			log := &recordingLogger{}
+			defer useLogger(log)()
+
+			exitCode := -1
+			defer func(f func(int)) { ExitFunc = f }(ExitFunc)
+			ExitFunc = func(code int) { exitCode = code }
+
+			fail(errors.New("connection refused"), "connecting to %s on port %d", "db", 5432)
+
+			want := "connecting to db on port 5432: connection refused"
+			if len(log.errors) != 1 || log.errors[0] != want {
+				t.Errorf("logged %q, want [%q]", log.errors, want)
+			}
+			if exitCode != FailExitCode {
+				t.Errorf("exit code = %d, want %d", exitCode, FailExitCode)
+			}
+		})
+	}
+}
+
+func TestFatalOnErrorNil(t *testing.T) {
+	defer func(f func(int)) { ExitFunc = f }(ExitFunc)
+	ExitFunc = func(code int) { t.Fatalf("ExitFunc(%d) called for a nil error", code) }
+
+	FatalOnError(nil, "unused")
+}
+
+func TestExponentialBackoffWithJitterBounds(t *testing.T) {
+	const baseMS, maxMS = 1000, 64000
+	for attempt := 0; attempt < 12; attempt++ {
+		value := cappedExponentialMS(attempt, baseMS, maxMS)
+		for i := 0; i < 100; i++ {
+			if got := ExponentialBackoffWithJitter(attempt, baseMS, maxMS, JitterNone); got != value {
+				t.Fatalf("JitterNone attempt %d = %d, want %d", attempt, got, value)
+			}
+			if got := ExponentialBackoffWithJitter(attempt, baseMS, maxMS, JitterFull); got < 0 || got > value {
+				t.Fatalf("JitterFull attempt %d = %d, want within [0, %d]", attempt, got, value)
+			}
+			if got := ExponentialBackoffWithJitter(attempt, baseMS, maxMS, JitterEqual); got < value/2 || got > value {
+				t.Fatalf("JitterEqual attempt %d = %d, want within [%d, %d]", attempt, got, value/2, value)
+			}
+		}
+	}
+}
+
+func TestExponentialBackoffPctSpreadScalesWithBase(t *testing.T) {
+	defer SetRandSource(nil)
+	SetRandSource(rand.NewSource(1))
+
+	spread := func(attempt int) int {
+		value := 1000 << attempt
+		lo, hi := value*2, 0
+		for i := 0; i < 1000; i++ {
+			got := ExponentialBackoffPct(attempt, 1000, 1<<30, 0.2)
+			if got < value*8/10 || got > value*12/10 {
+				t.Fatalf("ExponentialBackoffPct(%d) = %d, want within ±20%% of %d", attempt, got, value)
+			}
+			if got < lo {
+				lo = got
+			}
+			if got > hi {
+				hi = got
+			}
+		}
+		return hi - lo
+	}
+
+	small, large := spread(0), spread(4)
+	if large < small*8 {
+		t.Errorf("spread at attempt 4 = %dms, want much wider than %dms at attempt 0", large, small)
+	}
+
+	if got := ExponentialBackoffPct(10, 1000, 5000, 0.2); got != 5000 {
+		t.Errorf("ExponentialBackoffPct(10, 1000, 5000, 0.2) = %d, want the cap of 5000", got)
+	}
+}
+
+func TestExponentialBackoffMatchesMS(t *testing.T) {
+	defer SetRandSource(nil)
+
+	for attempt := 0; attempt < 10; attempt++ {
+		for _, jitterMS := range []int{0, 500} {
+			SetRandSource(rand.NewSource(int64(attempt)))
+			want := time.Duration(ExponentialBackoffMS(attempt, jitterMS, 64000)) * time.Millisecond
+			SetRandSource(rand.NewSource(int64(attempt)))
+			got := ExponentialBackoff(attempt, time.Duration(jitterMS)*time.Millisecond, 64*time.Second)
+			if got != want {
+				t.Errorf("ExponentialBackoff(%d, %dms, 64s) = %s, want %s", attempt, jitterMS, got, want)
+			}
+		}
+	}
+}
+
+func TestClampBackoffMS(t *testing.T) {
+	tests := []struct {
+		backoffMS, minMS, maxMS int
+		want                    int
+	}{
+		{500, 1000, 5000, 1000},  // raised to the floor
+		{9000, 1000, 5000, 5000}, // lowered to the cap
+		{3000, 1000, 5000, 3000}, // unchanged
+		{3000, 9000, 5000, 5000}, // the max wins over the min
+	}
+	for _, tt := range tests {
+		if got := ClampBackoffMS(tt.backoffMS, tt.minMS, tt.maxMS); got != tt.want {
+			t.Errorf("ClampBackoffMS(%d, %d, %d) = %d, want %d", tt.backoffMS, tt.minMS, tt.maxMS, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffMinimumClamp(t *testing.T) {
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	tests := []struct {
+		name    string
+		attempt int
+		minMS   int
+		want    time.Duration
+	}{
+		{"floor", 0, 2000, 2 * time.Second},
+		{"cap", 10, 2000, 5 * time.Second},
+		{"floor above cap", 0, 9000, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		start := clock.Now()
+		Backoff(tt.attempt, 0, 5000, WithMinBackoff(tt.minMS))
+		if got := clock.Now().Sub(start); got != tt.want {
+			t.Errorf("%s: Backoff paused for %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNextBackoffMinimumClamp(t *testing.T) {
+	cfg := newConfig([]Option{WithBackoff(NewConstant(0)), WithMinBackoff(500)})
+	if got := cfg.nextBackoff(0); got != 500*time.Millisecond {
+		t.Errorf("nextBackoff with a 500ms floor = %s, want 500ms", got)
+	}
+
+	cfg = newConfig([]Option{WithBackoff(NewConstant(0)), WithMinBackoff(9000), WithMaxBackoff(5000)})
+	if got := cfg.nextBackoff(0); got != 5*time.Second {
+		t.Errorf("nextBackoff with a floor above the max = %s, want 5s", got)
+	}
+}
+
+func TestBackoffContextDeadline(t *testing.T) {
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	t.Run("deadline unset", func(t *testing.T) {
+		start := clock.Now()
+		if err := BackoffContext(context.Background(), 2, 0, 64000); err != nil {
+			t.Fatalf("BackoffContext = %v, want nil", err)
+		}
+		if got := clock.Now().Sub(start); got != 4*time.Second {
+			t.Errorf("paused for %s, want 4s", got)
+		}
+	})
+
+	t.Run("deadline after backoff", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Hour))
+		defer cancel()
+
+		start := clock.Now()
+		if err := BackoffContext(ctx, 2, 0, 64000); err != nil {
+			t.Fatalf("BackoffContext = %v, want nil", err)
+		}
+		if got := clock.Now().Sub(start); got != 4*time.Second {
+			t.Errorf("paused for %s, want 4s", got)
+		}
+	})
+
+	t.Run("deadline within backoff", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Second))
+		defer cancel()
+
+		start := clock.Now()
+		if err := BackoffContext(ctx, 2, 0, 64000); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("BackoffContext = %v, want context.DeadlineExceeded", err)
+		}
+		if got := clock.Now().Sub(start); got != time.Second {
+			t.Errorf("paused for %s, want to be cut short at 1s", got)
+		}
+	})
+
+	t.Run("deadline passed", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(-time.Second))
+		defer cancel()
+
+		start := clock.Now()
+		if err := BackoffContext(ctx, 2, 0, 64000); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("BackoffContext = %v, want context.DeadlineExceeded", err)
+		}
+		if got := clock.Now().Sub(start); got != 0 {
+			t.Errorf("paused for %s, want no pause", got)
+		}
+	})
+}
+
+func TestMaxTotalBackoff(t *testing.T) {
+	// Pauses after attempts 0 to 3 with up to 99ms of jitter, the last capped.
+	want := (1099 + 2099 + 4099 + 5000) * time.Millisecond
+	if got := MaxTotalBackoff(5, 100, 5000); got != want {
+		t.Errorf("MaxTotalBackoff(5, 100, 5000) = %s, want %s", got, want)
+	}
+	if got := MaxTotalBackoff(1, 100, 5000); got != 0 {
+		t.Errorf("MaxTotalBackoff(1, 100, 5000) = %s, want 0 with no retries", got)
+	}
+	if got := MaxTotalBackoff(0, 100, 5000); got != time.Duration(math.MaxInt64) {
+		t.Errorf("MaxTotalBackoff(0, 100, 5000) = %s, want unbounded for unlimited retries", got)
+	}
+}
+
+func TestMaxTotalBackoffMatchesRetry(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	defer SetRandSource(nil)
+
+	worst := MaxTotalBackoff(5, 100, 5000)
+	for seed := int64(0); seed < 20; seed++ {
+		SetRandSource(rand.NewSource(seed))
+		start := clock.Now()
+		Retry("max-total", 5, func() error {
+			return errors.New("failing")
+		}, WithJitter(100), WithMaxBackoff(5000))
+		if got := clock.Now().Sub(start); got > worst || got < worst-4*100*time.Millisecond {
+			t.Errorf("seed %d: Retry slept %s, want within 400ms below the worst case of %s", seed, got, worst)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget limits the total number of retries made across any number of
+// operations that share it, preventing a failing dependency from causing retry
+// amplification.  It is a token bucket holding up to maxRetries tokens that
+// refills at a rate of maxRetries per window; each retry consumes one token.
+//
+// Pass a Budget to the retry helpers with WithBudget.  A Budget is safe for
+// concurrent use.
+type Budget struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64 // tokens per nanosecond
+	tokens   float64
+	last     time.Time
+}
+
+// NewBudget returns a Budget that allows up to maxRetries retries per window.
+func NewBudget(maxRetries int, window time.Duration) *Budget {
+	b := &Budget{
+		capacity: float64(maxRetries),
+		tokens:   float64(maxRetries),
+		last:     currentClock().Now(),
+	}
+	if window > 0 {
+		b.rate = float64(maxRetries) / float64(window)
+	}
+	return b
+}
+
+// Allow reports whether a retry may be made and, if so, consumes one retry from
+// the budget.
+func (b *Budget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := currentClock().Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,166 @@
+package recovery
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker.Execute when the breaker is open
+// and the call was rejected without running f.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed means calls are allowed through.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means calls are rejected with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen means the cooldown has elapsed and a single trial call is
+	// allowed through to determine whether the downstream has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops calling a failing operation so that a struggling
+// downstream is not overwhelmed with retries.  After failureThreshold consecutive
+// failures the breaker opens and rejects calls until a cooldown has elapsed.  The
+// cooldown is computed with the breaker's BackoffStrategy using the number of
+// times in a row the breaker has opened, so a downstream that stays unhealthy is
+// probed less and less often.
+//
+// Once the cooldown has elapsed the breaker becomes half-open and allows a single
+// trial call.  If it succeeds the breaker closes, otherwise it opens again.
+//
+// A CircuitBreaker is safe for concurrent use.
+type CircuitBreaker struct {
+	opName           string
+	failureThreshold int
+	opts             []Option
+	strategy         BackoffStrategy
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	trips     int
+	openUntil time.Time
+	trial     bool
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker that opens after
+// failureThreshold consecutive failures.  opName is used when logging panics and
+// state changes.  WithBackoff can be used to change how the cooldown is computed.
+func NewCircuitBreaker(opName string, failureThreshold int, opts ...Option) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &CircuitBreaker{
+		opName:           opName,
+		failureThreshold: failureThreshold,
+		opts:             opts,
+		strategy:         newConfig(opts).strategy,
+	}
+}
+
+// config resolves the breaker's options for a single call so that a Logger set
+// with SetLogger after the breaker was created is used.  The strategy resolved at
+// construction is kept, since the cooldown may depend on its state.
+func (cb *CircuitBreaker) config() *config {
+	cfg := newConfig(cb.opts)
+	cfg.strategy = cb.strategy
+	return cfg
+}
+
+// Execute runs f if the breaker allows it and records the outcome.  A panic in f
+// is recovered as it is by DontPanic and counts as a failure.  If the breaker is
+// open then f is not run and ErrCircuitOpen is returned.
+func (cb *CircuitBreaker) Execute(f func() error) error {
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+
+	cfg := cb.config()
+	err := dontPanic(cfg, cb.opName, f)
+	cb.record(cfg, err)
+
+	return err
+}
+
+// State returns the current state of the breaker.  It is suitable for use in
+// health checks.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && !currentClock().Now().Before(cb.openUntil) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// allow reports whether a call may proceed and claims the trial call when the
+// breaker is half-open.
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if currentClock().Now().Before(cb.openUntil) {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.trial = false
+	}
+
+	// Half-open: only a single trial call is allowed at a time.
+	if cb.trial {
+		return false
+	}
+	cb.trial = true
+	return true
+}
+
+// record updates the breaker with the outcome of a call run with cfg.
+func (cb *CircuitBreaker) record(cfg *config, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		if cb.state != CircuitClosed {
+			cfg.logWarn(cb.opName, 0, nil, "Circuit breaker %s closed", cb.opName)
+		}
+		cb.state = CircuitClosed
+		cb.failures = 0
+		cb.trips = 0
+		cb.trial = false
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.failureThreshold {
+		cooldown := cfg.nextBackoff(cb.trips)
+		cb.trips++
+		cb.state = CircuitOpen
+		cb.trial = false
+		cb.openUntil = currentClock().Now().Add(cooldown)
+		cfg.logWarn(cb.opName, 0, err, "Circuit breaker %s opened for %s after %d consecutive failures", cb.opName, cooldown, cb.failures)
+	}
+}
@@ -0,0 +1,20 @@
+package recovery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCircuitBreakerUsesLoggerSetAfterCreation(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	cb := NewCircuitBreaker("payments", 1)
+
+	log := &recordingLogger{}
+	SetLogger(log)
+	cb.Execute(func() error { return errors.New("unavailable") })
+
+	if len(log.warns) != 1 || !strings.Contains(log.warns[0], "opened") {
+		t.Errorf("logged %q, want the open warning on the Logger set after creation", log.warns)
+	}
+}
@@ -0,0 +1,46 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and the ability to wait for time to pass.  The
+// helpers in this package use a Clock for every sleep and runtime measurement so
+// that tests can substitute a fake implementation that advances instantly.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock implements Clock using the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var (
+	clockMu  sync.RWMutex
+	pkgClock Clock = realClock{}
+)
+
+// SetClock replaces the Clock used by the package.  Passing nil restores the
+// default real-time clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+
+	clockMu.Lock()
+	pkgClock = c
+	clockMu.Unlock()
+}
+
+// currentClock returns the Clock most recently set with SetClock.
+func currentClock() Clock {
+	clockMu.RLock()
+	defer clockMu.RUnlock()
+	return pkgClock
+}
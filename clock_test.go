@@ -0,0 +1,50 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when it sleeps or is advanced, so
+// that tests do not wait in real time.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// newFakeClock returns a fakeClock that starts at the real current time, so that
+// deadlines of real contexts line up with it.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// useClock installs c as the package Clock until the returned function is
+// called.
+func useClock(c Clock) (restore func()) {
+	SetClock(c)
+	return func() { SetClock(nil) }
+}
@@ -5,11 +5,15 @@ package recovery
 */
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"runtime"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/yabosh/logger"
 )
 
 // Restartable is a function that can be used in conjunction with WithRestart() that
@@ -25,23 +29,242 @@ type Restartable func() error
 //		panic("FAILURE")
 //	})
 //
-// This will trap the "FAILURE" panic and return it as a standard err.  It also
-// prints the stack trace to assist in debugging the panic.
+// This will trap the "FAILURE" panic and return it as a *PanicError.  The stack
+// trace is captured on the returned error and logged to assist in debugging the
+// panic.  Pass WithPrintStack() to also print the stack trace to os.Stderr.
 //
 // opName is a string value that is logged if a panic occurs to help identify
 // the goroutine affected.
-func DontPanic(opName string, f Restartable) (err error) {
+//
+// DontPanic is a thin wrapper that calls DontPanicContext with
+// context.Background().
+func DontPanic(opName string, f Restartable, opts ...Option) error {
+	return DontPanicContext(context.Background(), opName, ignoreContext(f), opts...)
+}
+
+// DontPanicContext behaves like DontPanic but passes ctx to f.  If ctx is already
+// done then f is not called and ctx.Err() is returned.  ctx is also passed to a
+// ContextLogger or slog handler when the panic is logged.
+//
+// DontPanic, WithRestart, UntilSuccessful, Retry and Backoff each have a Context
+// twin that takes a context.Context as its first argument: DontPanicContext,
+// WithRestartContext, UntilSuccessfulContext, RetryContext and BackoffContext.
+// Cancelling the context interrupts any pending sleep and the twin returns
+// ctx.Err(), and those five helpers are thin wrappers that call their twin with
+// context.Background().  Each twin except UntilSuccessfulContext also passes the
+// context to f; RetryContext with a maxAttempts of 0 is the equivalent of
+// UntilSuccessfulContext for an f that needs it.  The other variants, such as
+// WithRestartN and RetryValue, have no Context twin.
+func DontPanicContext(ctx context.Context, opName string, f func(context.Context) error, opts ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return dontPanic(newConfig(opts).withContext(ctx), opName, func() error {
+		return f(ctx)
+	})
+}
+
+// DontPanicRecover behaves like DontPanic but also reports whether the error was
+// the result of a recovered panic rather than an error returned by f.
+func DontPanicRecover(opName string, f Restartable, opts ...Option) (recovered bool, err error) {
+	return recoverPanic(newConfig(opts), opName, f)
+}
+
+// DontPanicCleanup behaves like DontPanic but calls onPanic if f panics.  onPanic
+// runs inside the recover handler, before the panic is logged and the error is
+// returned, which makes it suitable for releasing a lock or closing a connection
+// that f was unable to clean up itself.  onPanic is not called when f returns
+// normally, with or without an error.  It must not panic.
+//
+// onPanic is intended for cleanup and is separate from the observability hook
+// configured with WithOnPanic; both are called if both are set.
+func DontPanicCleanup(opName string, f Restartable, onPanic func(), opts ...Option) (err error) {
+	cfg := newConfig(opts)
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			if onPanic != nil {
+				onPanic()
+			}
+			err = handlePanic(cfg, opName, panicErr)
+		}
+	}()
+
+	return f()
+}
+
+// DontPanicTimeout runs f with the same panic protection as DontPanic but gives
+// up waiting for it after d.  f receives a context that is cancelled when d
+// elapses and it should return promptly once that happens.  If f has not
+// returned by then DontPanicTimeout returns an error wrapping
+// context.DeadlineExceeded without waiting any longer.
+//
+// Go cannot stop a goroutine from the outside, so an f that ignores its context
+// keeps running in the background after the timeout.  A panic in it is still
+// recovered and logged, but its result is discarded.
+func DontPanicTimeout(opName string, d time.Duration, f func(context.Context) error, opts ...Option) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- DontPanic(opName, func() error {
+			return f(ctx)
+		}, opts...)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%s did not finish within %s: %w", opName, d, ctx.Err())
+	}
+}
+
+// dontPanic implements DontPanic using an already resolved config.
+func dontPanic(cfg *config, opName string, f Restartable) error {
+	_, err := recoverPanic(cfg, opName, f)
+	return err
+}
+
+// recoverPanic runs f, trapping and reporting any panic according to cfg.
+func recoverPanic(cfg *config, opName string, f Restartable) (recovered bool, err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			recovered = true
+			err = handlePanic(cfg, opName, panicErr)
+		}
+	}()
+
+	return false, f()
+}
+
+// Recover traps a panic in the function that defers it, in the same way as
+// DontPanic, and stores the resulting *PanicError in *errp.  It is intended to be
+// deferred at the top of a function that cannot easily be wrapped in a closure:
+//
+//	func process() (err error) {
+//		defer recovery.Recover("process", &err)
+//		...
+//	}
+//
+// Recover must be called directly by defer for recover() to take effect, and errp
+// must point to a named return value of the deferring function so that the error
+// is visible to its caller.  *errp is left unchanged if no panic occurred.
+func Recover(opName string, errp *error, opts ...Option) {
+	if panicErr := recover(); panicErr != nil {
+		err := handlePanic(newConfig(opts), opName, panicErr)
+		if errp != nil {
+			*errp = err
+		}
+	}
+}
+
+// handlePanic records, logs and reports the recovered value panicErr and returns
+// it as a *PanicError.  It must be called from the deferred function that
+// recovered the panic so that the captured stack is that of the panic.
+func handlePanic(cfg *config, opName string, panicErr interface{}) error {
+	atomic.AddInt64(&metricsFor(opName).panics, 1)
+	if cfg.suppressStack {
+		// Only the header line is needed to identify the goroutine.
+		var buf [64]byte
+		gid := goroutineID(buf[:runtime.Stack(buf[:], false)])
+		err := &PanicError{Value: panicErr, GoroutineID: gid}
+		cfg.logError(opName, 0, err, "PANIC: OPNAME=%s GOROUTINE=%d ERR=%#v", opName, gid, panicErr)
+		return finishPanic(cfg, opName, panicErr, err)
+	}
+
+	stack := debug.Stack()
+	gid := goroutineID(stack)
+	err := &PanicError{Value: panicErr, Stack: stack, Frames: captureFrames(), GoroutineID: gid}
+	cfg.logError(opName, 0, err, "PANIC: OPNAME=%s GOROUTINE=%d ERR=%#v STACK=%s", opName, gid, panicErr, stack)
+	if cfg.printStack {
+		os.Stderr.Write(stack)
+	}
+	return finishPanic(cfg, opName, panicErr, err)
+}
+
+// finishPanic invokes the hooks configured for a recovered panic and re-raises it
+// if cfg says that it should not be recovered.
+func finishPanic(cfg *config, opName string, panicErr interface{}, err *PanicError) error {
+	if cfg.onPanic != nil {
+		cfg.onPanic(opName, panicErr, err.Stack)
+	}
+	cfg.notify(opName, Event{Kind: EventPanic, Err: err})
+	if cfg.fatalRuntimeErrors {
+		if _, ok := panicErr.(runtime.Error); ok {
+			cfg.logError(opName, 0, err, "FATAL: OPNAME=%s runtime error will not be recovered", opName)
+			panic(panicErr)
+		}
+	}
+	if cfg.rethrowIf != nil && cfg.rethrowIf(panicErr) {
+		panic(panicErr)
+	}
+
+	return err
+}
+
+// Try runs f and converts a panic into a *PanicError, like DontPanic, but without
+// logging, printing the stack trace or invoking any hooks.  It is intended for hot
+// paths where the caller handles the error itself.  The stack trace is still
+// captured on the returned error; it is only paid for when a panic occurs.
+func Try(f Restartable) (err error) {
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
-			logger.Error("PANIC: OPNAME=%s ERR=%#v", opName, panicErr)
-			err = fmt.Errorf("%#v", panicErr)
-			debug.PrintStack()
+			stack := debug.Stack()
+			err = &PanicError{Value: panicErr, Stack: stack, Frames: captureFrames(), GoroutineID: goroutineID(stack)}
 		}
 	}()
 
 	return f()
 }
 
+// DontPanicAll runs each of fns in turn with the same panic protection as
+// DontPanic.  Every function is run even if an earlier one fails.  The returned
+// slice has one entry per function, in the same order, which is nil if the
+// function succeeded.  Panics are logged with an opName of the form "opName[i]".
+func DontPanicAll(opName string, fns ...Restartable) []error {
+	cfg := newConfig(nil)
+	errs := make([]error, len(fns))
+	for i, f := range fns {
+		errs[i] = dontPanic(cfg, fmt.Sprintf("%s[%d]", opName, i), f)
+	}
+	return errs
+}
+
+// DontPanicAllConcurrent behaves like DontPanicAll except that each function is
+// run in its own goroutine.  It returns once every function has returned.
+func DontPanicAllConcurrent(opName string, fns ...Restartable) []error {
+	cfg := newConfig(nil)
+	errs := make([]error, len(fns))
+
+	var wg sync.WaitGroup
+	for i, f := range fns {
+		wg.Add(1)
+		go func(i int, f Restartable) {
+			defer wg.Done()
+			errs[i] = dontPanic(cfg, fmt.Sprintf("%s[%d]", opName, i), f)
+		}(i, f)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// DontPanicValue is a variant of DontPanic for functions that compute a value.
+// If f panics then the panic is trapped and logged exactly as it is by DontPanic
+// and the zero value of T is returned along with the recovered error.  Otherwise
+// the result of f is returned unchanged.
+func DontPanicValue[T any](opName string, f func() (T, error), opts ...Option) (T, error) {
+	var result T
+	err := DontPanic(opName, func() error {
+		var err error
+		result, err = f()
+		return err
+	}, opts...)
+
+	return result, err
+}
+
 // WithRestart is a failsafe mechanism used to ensure that long running tasks do not terminate
 // prematurely.  In the event of a panic the error is trapped and logged and then the goroutine function is restarted.
 // If the function returns an error then it will be restarted.  If the function causes a panic then it will be restarted
@@ -66,55 +289,437 @@ func DontPanic(opName string, f Restartable) (err error) {
 // fails immediately.  This would essentially put WithRestart into an infinite
 // loop and spam the log with stack traces. In order to prevent this an exponential
 // backoff is used to restart the job if it has run for less than 60 seconds.
+// The threshold can be changed with WithStabilityThreshold().
+//
+// The behavior of WithRestart can be tuned with options, for example:
+//
+//	go WithRestart("mytask", task, WithMaxBackoff(30000), WithJitter(500),
+//		WithStabilityThreshold(5*time.Minute), WithLogger(myLogger))
+//
+// Pass WithRestartOnPanicOnly() to restart f only when it panics; an error
+// returned by f then ends the loop and is returned by WithRestartContext and
+// WithRestartN.
+//
+// WithRestart stops restarting f if it returns an error marked with Permanent or
+// one rejected by the predicate given to WithIsRetryable.
+//
+// A run of f() that lasts longer than the stability threshold is treated as a
+// success: the attempt counter used to compute the backoff is reset to zero so
+// the next failure is restarted with the shortest delay.  Use WithOnReset() to be
+// notified when this happens.
+//
+// Since f() is expected to be a long running function then any instance that runs
+// for less than the stability threshold (60 seconds by default) will be subject to
+// the backoff function, while one that runs for at least the threshold is restarted
+// immediately.
+//
+// WithRestart is a thin wrapper that calls WithRestartContext with
+// context.Background().
+func WithRestart(opName string, f Restartable, opts ...Option) {
+	WithRestartContext(context.Background(), opName, ignoreContext(f), opts...)
+}
+
+// WithRestartContext behaves like WithRestart but passes ctx to f and stops
+// restarting f once ctx is cancelled.  The backoff between restarts is also
+// interrupted by cancellation so shutdown is not delayed by a pending restart.
+//
+// WithRestartContext returns nil if f terminates without an error and ctx.Err()
+// once the context has been cancelled.  It also returns the error from f when
+// that error is marked with Permanent or rejected by WithIsRetryable, and, under
+// WithRestartOnPanicOnly, the error from a run of f that did not panic.
+func WithRestartContext(ctx context.Context, opName string, f func(context.Context) error, opts ...Option) error {
+	return restartLoop(ctx, newConfig(opts), opName, 0, f)
+}
+
+// WithRestartN behaves like WithRestart except that f will be started at most
+// maxAttempts times.  If every attempt fails then WithRestartN gives up and
+// returns an error that joins the errors from each attempt, as errors.Join does,
+// so that any of them can be inspected with errors.Is and errors.As.  Identical
+// consecutive errors are only included once.
+//
+// A maxAttempts of 0 means that f is restarted indefinitely, exactly like
+// WithRestart.
+func WithRestartN(opName string, maxAttempts int, f Restartable, opts ...Option) error {
+	return restartLoop(context.Background(), newConfig(opts), opName, maxAttempts, func(context.Context) error {
+		return f()
+	})
+}
+
+// StartWithRestart launches WithRestart in a new goroutine and returns a function
+// that stops it.  Calling stop prevents any further restarts of f, interrupts a
+// pending backoff and then waits for the current invocation of f to return.
 //
-// Since f() is expected to be a long running function then any instance
-// that runs less than 10 seconds will be subject to the backoff function
-func WithRestart(opName string, f Restartable) {
+// Because f does not receive a context it is not interrupted by stop; stop blocks
+// until f returns on its own.  stop is idempotent and may be called more than once
+// or from multiple goroutines.
+func StartWithRestart(opName string, f Restartable, opts ...Option) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		WithRestartContext(ctx, opName, func(context.Context) error {
+			return f()
+		}, opts...)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// restartLoop implements the restart and backoff behavior shared by the
+// WithRestart family.  A maxAttempts of 0 means there is no limit on the
+// number of times f will be started.
+func restartLoop(ctx context.Context, cfg *config, opName string, maxAttempts int, f func(context.Context) error) error {
+	cfg = cfg.withContext(ctx)
 	var attempt int
-	const jitter = 100
-	const maxBackoff = 64000
-	const minFunctionRuntimeSecs = 60
+	var runs int
+	var failures int
+	var errs []error
+	clock := currentClock()
+	health := newHealthReporter(opName, cfg.onStateChange, cfg.onStable)
+	limiter := logLimiter{every: cfg.logEvery}
+	setStatus(opName, 0, false)
+	// However the loop ends the task is no longer waiting to be restarted.
+	defer func() {
+		setStatus(opName, failures, false)
+	}()
 
 	for {
-		start := time.Now()
-		err := DontPanic(opName, f)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if cfg.beforeRun != nil {
+			if err := cfg.beforeRun(ctx); err != nil {
+				return err
+			}
+		}
+
+		start := clock.Now()
+		stopWatch := health.watch(clock, cfg.stabilityThreshold)
+		panicked, err := recoverPanic(cfg, opName, func() error {
+			return f(ctx)
+		})
+		stopWatch()
+		runs++
 
 		if err == nil {
-			break
+			failures = 0
+			return nil
 		}
+		setLastError(opName, err)
 
-		if time.Since(start) < time.Duration(minFunctionRuntimeSecs)*time.Second {
+		if cfg.panicOnly && !panicked {
+			// f() finished with an error rather than crashing.
+			failures = 0
+			return err
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if !cfg.retryable(err) {
+			cfg.logError(opName, runs, err, "Service %s failed with a permanent error and will not be restarted: %s", opName, err)
+			failures++
+			return err
+		}
+
+		if maxAttempts > 0 {
+			// Keep the distinct failures for the error returned on exhaustion.
+			if len(errs) == 0 || errs[len(errs)-1].Error() != err.Error() {
+				errs = append(errs, err)
+			}
+
+			if runs >= maxAttempts {
+				cfg.logError(opName, runs, err, "Service %s failed %d times and will not be restarted", opName, runs)
+				atomic.AddInt64(&metricsFor(opName).exhausted, 1)
+				failures++
+				return fmt.Errorf("%s failed after %d attempts: %w", opName, runs, errors.Join(errs...))
+			}
+		}
+
+		ran := clock.Now().Sub(start)
+		var next time.Duration
+		if cfg.shouldBackoff(ran, err, attempt) {
 			// Only backoff if f() terminates very quickly
-			Backoff(attempt, jitter, maxBackoff)
+			health.set(false)
+			failures++
+			next = cfg.restartDelay(attempt)
+			cfg.retrying(opName, runs, err, next)
+			setStatus(opName, failures, true)
+			cfg.backingOff(opName, runs, next)
+			if err := sleepContext(ctx, next); err != nil {
+				return err
+			}
+			setStatus(opName, failures, false)
 			attempt++
 		} else {
 			// f() ran longer than the threshold so don't use any backoff
 			// if it fails and must be restarted.
+			if attempt > 0 && cfg.onReset != nil {
+				cfg.onReset(opName)
+			}
 			attempt = 0
+			failures = 1
+			health.set(true)
+			if ran < cfg.minRestartDelay {
+				next = cfg.minRestartDelay - ran
+			}
+			cfg.retrying(opName, runs, err, next)
+			setStatus(opName, failures, false)
+			if next > 0 {
+				cfg.backingOff(opName, runs, next)
+				if err := sleepContext(ctx, next); err != nil {
+					return err
+				}
+			}
+		}
+		if ok, suppressed, since := limiter.allow(clock.Now()); ok {
+			if suppressed > 0 {
+				cfg.logRetry(opName, runs, err, "Restarting service %s after it ran for %s with a backoff of %s (restarted %d times in the last %s)", opName, ran, next, suppressed+1, since.Round(time.Second))
+			} else {
+				cfg.logRetry(opName, runs, err, "Restarting service %s after it ran for %s with a backoff of %s", opName, ran, next)
+			}
 		}
-		logger.Warn("Restarting service %s", opName)
 	}
 }
 
 // Retry a function until it completes without returning an error.  This is useful when
 // an application relies on external services to be available on startup.
-func UntilSuccessful(opName string, f func() error) {
+//
+// If f returns an error marked with Permanent, or one rejected by the predicate
+// given to WithIsRetryable, then UntilSuccessful stops retrying and returns it.
+//
+// UntilSuccessful is a thin wrapper that calls UntilSuccessfulContext with
+// context.Background().
+func UntilSuccessful(opName string, f func() error, opts ...Option) error {
+	return UntilSuccessfulContext(context.Background(), opName, f, opts...)
+}
+
+// UntilSuccessfulContext retries f until it completes without returning an error
+// or until ctx is cancelled, in which case ctx.Err() is returned.  The backoff
+// between attempts is interrupted by cancellation.
+//
+// A context created with context.WithTimeout or context.WithDeadline can be used
+// to cap the total amount of time spent retrying f.
+func UntilSuccessfulContext(ctx context.Context, opName string, f func() error, opts ...Option) error {
+	_, _, err := retryLoop(ctx, newConfig(opts), opName, 0, ignoreContext(f))
+	return err
+}
+
+// UntilSuccessfulN retries f until it completes without returning an error or
+// until f has been attempted maxAttempts times.  It returns the number of attempts
+// made and, only when every attempt failed, an error wrapping the final failure.
+//
+// A maxAttempts of 0 means that f is retried indefinitely, exactly like
+// UntilSuccessful.
+func UntilSuccessfulN(opName string, maxAttempts int, f func() error, opts ...Option) (attempts int, err error) {
+	attempts, _, err = retryLoop(context.Background(), newConfig(opts), opName, maxAttempts, ignoreContext(f))
+	return attempts, err
+}
+
+// UntilSuccessfulStats retries f in the same way as UntilSuccessfulN and also
+// returns the total time spent sleeping between attempts.  Unlike the wall-clock
+// time of the whole loop, backoff excludes the time taken by f itself, so it shows
+// how much of the delay was caused by the backoff rather than by a slow
+// dependency.
+//
+// Sample usage:
+//
+//	attempts, backoff, err := recovery.UntilSuccessfulStats("connect", 0, connect)
+//	log.Printf("connected after %d attempts, spent %s backing off", attempts, backoff)
+func UntilSuccessfulStats(opName string, maxAttempts int, f func() error, opts ...Option) (attempts int, backoff time.Duration, err error) {
+	return retryLoop(context.Background(), newConfig(opts), opName, maxAttempts, ignoreContext(f))
+}
+
+// Retry attempts f up to maxAttempts times, pausing between attempts using the same
+// backoff as UntilSuccessful.  It returns nil as soon as an attempt succeeds, or an
+// error wrapping the last failure once maxAttempts attempts have failed.  Attempts
+// are numbered from 1 in the log.
+//
+// A maxAttempts of 0 means that f is retried indefinitely.
+//
+// Retry is a thin wrapper that calls RetryContext with context.Background().
+func Retry(opName string, maxAttempts int, f func() error, opts ...Option) error {
+	return RetryContext(context.Background(), opName, maxAttempts, ignoreContext(f), opts...)
+}
+
+// RetryIndexed behaves like Retry but passes f the number of the attempt being
+// made, starting at 1.  This lets f change its behavior between attempts without
+// keeping its own counter, for example by switching to a secondary replica from
+// the second attempt onwards:
+//
+//	err := recovery.RetryIndexed("query", 3, func(attempt int) error {
+//		if attempt >= 2 {
+//			return query(secondary)
+//		}
+//		return query(primary)
+//	})
+func RetryIndexed(opName string, maxAttempts int, f func(attempt int) error, opts ...Option) error {
 	var attempt int
-	const jitter = 100
-	const maxBackoff = 64000
+	return Retry(opName, maxAttempts, func() error {
+		attempt++
+		return f(attempt)
+	}, opts...)
+}
+
+// RetryValue is a variant of Retry for functions that compute a value, such as a
+// database query or an HTTP request.  It returns the value from the first
+// successful attempt.  If every attempt fails then it returns the zero value of T
+// along with the same error that Retry would return, even if the failed attempts
+// returned a partial value.
+func RetryValue[T any](opName string, maxAttempts int, f func() (T, error), opts ...Option) (T, error) {
+	return DoValue(NewPolicy(maxAttempts, opts...), opName, f)
+}
+
+// RetryWithFallback behaves like Retry but, if every attempt fails, runs fallback
+// with the error Retry would have returned and returns fallback's result instead.
+// This allows a caller to degrade gracefully, for example by serving stale data,
+// when a dependency is unavailable.  fallback is not run if any attempt succeeds.
+// A panic in fallback is recovered as it is by DontPanic.
+func RetryWithFallback(opName string, maxAttempts int, f func() error, fallback func(lastErr error) error, opts ...Option) error {
+	cfg := newConfig(opts)
+
+	_, _, err := retryLoop(context.Background(), cfg, opName, maxAttempts, ignoreContext(f))
+	if err == nil {
+		return nil
+	}
+
+	cfg.logWarn(opName, 0, err, "Operation %s failed, using fallback", opName)
+	return dontPanic(cfg, opName, func() error {
+		return fallback(err)
+	})
+}
+
+// RetryContext behaves like Retry but passes a context to f and stops retrying,
+// returning ctx.Err(), once ctx is cancelled.  When a Tracer is configured with
+// WithTracer the context passed to f carries the span for the current attempt.
+func RetryContext(ctx context.Context, opName string, maxAttempts int, f func(context.Context) error, opts ...Option) error {
+	return NewPolicy(maxAttempts, opts...).DoContext(ctx, opName, f)
+}
+
+// UntilSuccessfulDeadline retries f until it completes without returning an error,
+// just like UntilSuccessful, but gives up and returns the last error once another
+// attempt could not begin before deadline.  If deadline has already passed then f
+// is not attempted and context.DeadlineExceeded is returned.
+func UntilSuccessfulDeadline(deadline time.Time, opName string, f func() error, opts ...Option) error {
+	budget := deadline.Sub(currentClock().Now())
+	if budget <= 0 {
+		return context.DeadlineExceeded
+	}
+	return UntilSuccessful(opName, f, append(opts, WithMaxElapsedTime(budget))...)
+}
+
+// UntilSuccessfulTimeout retries f until it completes without returning an error,
+// just like UntilSuccessful, except that each attempt is given a context that is
+// cancelled after perAttempt.  An attempt that times out is treated as an ordinary
+// failure and is retried.
+//
+// f must honor the context for the timeout to have any effect; Go cannot interrupt
+// a function that ignores its context.
+func UntilSuccessfulTimeout(opName string, perAttempt time.Duration, f func(context.Context) error, opts ...Option) error {
+	return UntilSuccessful(opName, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), perAttempt)
+		defer cancel()
+		return f(ctx)
+	}, opts...)
+}
+
+// retryLoop implements the retry and backoff behavior shared by the
+// UntilSuccessful family and returns the number of times f was attempted.
+// A maxAttempts of 0 means there is no limit on the number of attempts.
+func retryLoop(ctx context.Context, cfg *config, opName string, maxAttempts int, f func(context.Context) error) (attempts int, slept time.Duration, err error) {
+	cfg = cfg.withContext(ctx)
+	var attempt int
+	clock := currentClock()
+	start := clock.Now()
+
+	defer func() {
+		event := Event{Kind: EventSuccess, Attempt: attempts, Err: err, Elapsed: clock.Now().Sub(start), Backoff: slept}
+		if err != nil {
+			event.Kind = EventFailure
+		}
+		cfg.notify(opName, event)
+	}()
 
 	for {
-		err := DontPanic(opName, f)
+		if err := ctx.Err(); err != nil {
+			return attempt, slept, err
+		}
+
+		if cfg.limiter != nil {
+			if err := cfg.limiter.Acquire(ctx); err != nil {
+				return attempt, slept, err
+			}
+		}
+
+		attemptCtx := ctx
+		var span AttemptSpan
+		if cfg.tracer != nil {
+			attemptCtx, span = cfg.tracer.StartAttempt(ctx, opName, attempt+1)
+		}
+
+		err := dontPanic(cfg, opName, func() error {
+			if cfg.limiter != nil {
+				defer cfg.limiter.Release()
+			}
+			return f(attemptCtx)
+		})
+
+		if span != nil {
+			span.End(err)
+		}
 
 		if err == nil {
-			break
+			return attempt + 1, slept, nil
+		}
+		setLastError(opName, err)
+
+		if !cfg.retryable(err) {
+			cfg.logError(opName, attempt+1, err, "Operation %s failed with a permanent error and will not be retried: %s", opName, err)
+			return attempt + 1, slept, err
+		}
+
+		if maxAttempts > 0 && attempt+1 >= maxAttempts {
+			cfg.logError(opName, attempt+1, err, "Operation %s failed %d times and will not be retried", opName, attempt+1)
+			atomic.AddInt64(&metricsFor(opName).exhausted, 1)
+			return attempt + 1, slept, fmt.Errorf("%s failed after %d attempts: %w", opName, attempt+1, err)
+		}
+
+		next := cfg.nextBackoff(attempt)
+		if cfg.maxElapsed > 0 && clock.Now().Sub(start)+next > cfg.maxElapsed {
+			cfg.logError(opName, attempt+1, err, "Operation %s failed %d times and could not be retried within %s", opName, attempt+1, cfg.maxElapsed)
+			atomic.AddInt64(&metricsFor(opName).exhausted, 1)
+			return attempt + 1, slept, fmt.Errorf("%s failed after %d attempts within %s: %w", opName, attempt+1, cfg.maxElapsed, err)
 		}
 
-		logger.Warn("Operation %s failed.  The operation will be retried.", opName)
+		if cfg.budget != nil && !cfg.budget.Allow() {
+			cfg.logError(opName, attempt+1, err, "Operation %s failed %d times and the retry budget is exhausted", opName, attempt+1)
+			atomic.AddInt64(&metricsFor(opName).exhausted, 1)
+			return attempt + 1, slept, fmt.Errorf("%s failed after %d attempts, retry budget exhausted: %w", opName, attempt+1, err)
+		}
 
-		Backoff(attempt, jitter, maxBackoff)
+		cfg.logRetry(opName, attempt+1, err, "Operation %s failed on attempt %d.  The operation will be retried.", opName, attempt+1)
+		cfg.retrying(opName, attempt+1, err, next)
+		cfg.backingOff(opName, attempt+1, next)
+		sleepStart := clock.Now()
+		err = sleepContext(ctx, next)
+		slept += clock.Now().Sub(sleepStart)
+		if err != nil {
+			return attempt + 1, slept, err
+		}
 		attempt++
 
-		logger.Warn("Retrying operation %s", opName)
+		cfg.logRetry(opName, attempt+1, nil, "Retrying operation %s (attempt %d)", opName, attempt+1)
+	}
+}
+
+// ignoreContext adapts f to the signature used by the context-aware loops.
+func ignoreContext(f func() error) func(context.Context) error {
+	return func(context.Context) error {
+		return f()
 	}
 }
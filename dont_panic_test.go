@@ -0,0 +1,151 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatusClearedWhenRestartLoopStops(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	failing := errors.New("failing")
+
+	t.Run("cancelled during backoff", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			WithRestartContext(ctx, "status-cancel", func(context.Context) error {
+				return failing
+			}, WithBackoff(NewConstant(60000)))
+		}()
+
+		waitFor(t, func() bool {
+			_, inBackoff, _ := Status("status-cancel")
+			return inBackoff
+		})
+		cancel()
+		<-done
+
+		if failures, inBackoff, _ := Status("status-cancel"); inBackoff || failures != 1 {
+			t.Errorf("Status = (%d, %t), want (1, false)", failures, inBackoff)
+		}
+	})
+
+	t.Run("permanent error", func(t *testing.T) {
+		WithRestart("status-permanent", func() error {
+			return Permanent(failing)
+		})
+		if failures, inBackoff, _ := Status("status-permanent"); inBackoff || failures != 1 {
+			t.Errorf("Status = (%d, %t), want (1, false)", failures, inBackoff)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		WithRestartN("status-exhausted", 3, func() error {
+			return failing
+		}, WithBackoff(NewConstant(0)), WithMinRestartDelay(0))
+		if failures, inBackoff, _ := Status("status-exhausted"); inBackoff || failures != 3 {
+			t.Errorf("Status = (%d, %t), want (3, false)", failures, inBackoff)
+		}
+	})
+}
+
+// waitFor polls cond until it returns true, failing the test after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStabilityThresholdBoundary(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	const threshold = 10 * time.Second
+	tests := []struct {
+		runtime     time.Duration
+		wantBackoff bool
+	}{
+		{threshold - time.Nanosecond, true},
+		{threshold, false},
+		{threshold + time.Second, false},
+	}
+	for _, tt := range tests {
+		var next time.Duration
+		WithRestartN("threshold", 2, func() error {
+			clock.Advance(tt.runtime)
+			return errors.New("failing")
+		},
+			WithStabilityThreshold(threshold),
+			WithBackoff(NewConstant(1000)),
+			WithOnRetry(func(opName string, attempt int, err error, d time.Duration) {
+				next = d
+			}))
+
+		if backedOff := next > 0; backedOff != tt.wantBackoff {
+			t.Errorf("run of %s: backed off = %t (next %s), want %t", tt.runtime, backedOff, next, tt.wantBackoff)
+		}
+	}
+}
+
+func TestMinRestartDelay(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	restartDelays := func(runtime time.Duration, opts ...Option) []time.Duration {
+		var delays []time.Duration
+		opts = append(opts, WithOnRetry(func(opName string, attempt int, err error, next time.Duration) {
+			delays = append(delays, next)
+		}))
+		WithRestartN("min-delay", 3, func() error {
+			clock.Advance(runtime)
+			return errors.New("failing")
+		}, opts...)
+		if len(delays) != 2 {
+			t.Fatalf("got %d restarts, want 2", len(delays))
+		}
+		return delays
+	}
+
+	t.Run("zero backoff", func(t *testing.T) {
+		for _, d := range restartDelays(0, WithBackoff(NewConstant(0))) {
+			if d != defaultMinRestartDelay {
+				t.Errorf("restart delay = %s, want the %s floor", d, defaultMinRestartDelay)
+			}
+		}
+	})
+
+	t.Run("no backoff", func(t *testing.T) {
+		never := WithShouldBackoff(func(time.Duration, error, int) bool { return false })
+		for _, d := range restartDelays(40*time.Millisecond, never) {
+			if d != 60*time.Millisecond {
+				t.Errorf("restart delay after a 40ms run = %s, want 60ms to make up the floor", d)
+			}
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		for _, d := range restartDelays(0, WithBackoff(NewConstant(0)), WithMinRestartDelay(time.Second)) {
+			if d != time.Second {
+				t.Errorf("restart delay = %s, want 1s", d)
+			}
+		}
+	})
+
+	t.Run("elapsed", func(t *testing.T) {
+		start := clock.Now()
+		restartDelays(0, WithBackoff(NewConstant(0)))
+		if got := clock.Now().Sub(start); got != 2*defaultMinRestartDelay {
+			t.Errorf("three instant failures took %s, want %s", got, 2*defaultMinRestartDelay)
+		}
+	})
+}
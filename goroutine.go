@@ -0,0 +1,80 @@
+package recovery
+
+import (
+	"context"
+	"sync"
+)
+
+// Go runs f in a new goroutine with the same panic protection as DontPanic.  A
+// panic in f is recovered and logged instead of terminating the process.
+//
+// Sample usage:
+//
+//	recovery.Go("flush-cache", func() {
+//		cache.Flush()
+//	})
+func Go(opName string, f func(), opts ...Option) {
+	go DontPanic(opName, func() error {
+		f()
+		return nil
+	}, opts...)
+}
+
+// GoRestart runs WithRestart in a new goroutine so that f is restarted whenever it
+// fails or panics.
+func GoRestart(opName string, f Restartable, opts ...Option) {
+	go WithRestart(opName, f, opts...)
+}
+
+// GoWait runs f in a new goroutine with the same panic protection as DontPanic,
+// adding one to wg before the goroutine starts and calling wg.Done when f
+// returns, even if it panics.  It is intended for fire-and-wait fan-out, where
+// several protected goroutines are started and then waited on together.
+//
+// Sample usage:
+//
+//	var wg sync.WaitGroup
+//	for _, shard := range shards {
+//		shard := shard
+//		recovery.GoWait(&wg, "warm-"+shard.Name, shard.Warm)
+//	}
+//	wg.Wait()
+func GoWait(wg *sync.WaitGroup, opName string, f Restartable, opts ...Option) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		DontPanic(opName, f, opts...)
+	}()
+}
+
+// RunTasks runs each function received from tasks with the same panic protection
+// as DontPanic, carrying on with the next task after one panics.  Tasks run one at
+// a time on the calling goroutine, so they run in the order they were sent.
+// RunTasks returns nil once tasks is closed and drained, or ctx.Err() once ctx is
+// done.  A task that is running when ctx is cancelled is allowed to finish, but
+// no further tasks are run.
+//
+// Sample usage:
+//
+//	go recovery.RunTasks(ctx, "dispatcher", taskQueue)
+func RunTasks(ctx context.Context, opName string, tasks <-chan func(), opts ...Option) error {
+	cfg := newConfig(opts).withContext(ctx)
+
+	for {
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			dontPanic(cfg, opName, func() error {
+				task()
+				return nil
+			})
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// healthReporter tracks whether a task run by WithRestart is healthy and
+// reports transitions to a StateChange callback and recoveries to an OnStable
+// callback.
+type healthReporter struct {
+	opName   string
+	fn       func(opName string, healthy bool)
+	onStable func(opName string, afterAttempts int)
+
+	mu      sync.Mutex
+	healthy bool
+	failed  int
+}
+
+// newHealthReporter returns a healthReporter for opName that starts out healthy.
+// fn and onStable may be nil in which case nothing is reported to them.
+func newHealthReporter(opName string, fn func(opName string, healthy bool), onStable func(opName string, afterAttempts int)) *healthReporter {
+	return &healthReporter{opName: opName, fn: fn, onStable: onStable, healthy: true}
+}
+
+// reporting reports whether h has any callbacks to call.
+func (h *healthReporter) reporting() bool {
+	return h.fn != nil || h.onStable != nil
+}
+
+// set records the current health and calls fn if it has changed.  Each call with
+// healthy set to false counts as a failed run, and onStable is called with the
+// number of failed runs when the task becomes healthy again.  Calls to the
+// callbacks are serialized.
+func (h *healthReporter) set(healthy bool) {
+	if !h.reporting() {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !healthy {
+		h.failed++
+	}
+	if h.healthy == healthy {
+		return
+	}
+	h.healthy = healthy
+	if h.fn != nil {
+		h.fn(h.opName, healthy)
+	}
+	if healthy {
+		if h.onStable != nil {
+			h.onStable(h.opName, h.failed)
+		}
+		h.failed = 0
+	}
+}
+
+// watch marks the task as healthy if the current run lasts longer than
+// threshold.  The returned function must be called when the run ends.
+func (h *healthReporter) watch(clock Clock, threshold time.Duration) (stop func()) {
+	h.mu.Lock()
+	healthy := h.healthy
+	h.mu.Unlock()
+
+	if !h.reporting() || healthy {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-clock.After(threshold):
+			h.set(true)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,48 @@
+package recovery
+
+import "net/http"
+
+// HTTPOpName is the operation name under which HTTPMiddleware reports panics.
+const HTTPOpName = "http"
+
+// HTTPMiddleware wraps next so that a panic in a request handler is recovered in
+// the same way as DontPanic: the panic is logged with its stack trace, any OnPanic
+// hook is invoked and a 500 Internal Server Error is written to the client.
+//
+// Every panic is reported under the fixed operation name HTTPOpName, so that the
+// metrics and hooks keyed by operation name do not grow with each distinct URL.
+// The method and path of the request are included in the log instead.
+//
+// A panic with http.ErrAbortHandler is re-raised so that net/http can abort the
+// response as the handler intended.  If the handler had already written part of
+// the response then the 500 status cannot be sent and the client sees a truncated
+// response.
+//
+// Sample usage:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("/orders", handleOrders)
+//	http.ListenAndServe(":8080", recovery.HTTPMiddleware(mux))
+func HTTPMiddleware(next http.Handler, opts ...Option) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if panicErr := recover(); panicErr != nil {
+				if panicErr == http.ErrAbortHandler {
+					panic(panicErr)
+				}
+				reqCfg := newConfig(opts).withContext(r.Context())
+				err := handlePanic(reqCfg, HTTPOpName, panicErr)
+				reqCfg.logError(HTTPOpName, 0, err, "Request %s %s panicked and was answered with %d", r.Method, r.URL.Path, http.StatusInternalServerError)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecoverHandler is a convenience for wrapping a single handler function with
+// HTTPMiddleware.
+func RecoverHandler(f http.HandlerFunc, opts ...Option) http.Handler {
+	return HTTPMiddleware(f, opts...)
+}
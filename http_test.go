@@ -0,0 +1,51 @@
+package recovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPMiddlewareUsesFixedOpName(t *testing.T) {
+	log := &recordingLogger{}
+	defer useLogger(log)()
+
+	var opNames []string
+	handler := HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), WithOnPanic(func(opName string, value interface{}, stack []byte) {
+		opNames = append(opNames, opName)
+	}))
+
+	for _, path := range []string{"/orders/1", "/orders/2"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("GET %s: status = %d, want 500", path, rec.Code)
+		}
+	}
+
+	if len(opNames) != 2 || opNames[0] != HTTPOpName || opNames[1] != HTTPOpName {
+		t.Errorf("OnPanic opNames = %q, want %q for every request", opNames, HTTPOpName)
+	}
+	if !strings.Contains(strings.Join(log.errors, "\n"), "GET /orders/2") {
+		t.Errorf("log %q does not mention the request", log.errors)
+	}
+}
+
+func TestHTTPMiddlewareUsesLoggerSetAfterWrapping(t *testing.T) {
+	defer useLogger(NopLogger{})()
+
+	handler := HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	log := &recordingLogger{}
+	SetLogger(log)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+
+	if len(log.errors) == 0 {
+		t.Errorf("panic was not logged to the Logger set after the handler was wrapped")
+	}
+}
@@ -0,0 +1,49 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// lastError is the most recent failure recorded for an operation.
+type lastError struct {
+	err error
+	at  time.Time
+}
+
+var (
+	lastErrorsMu sync.RWMutex
+	lastErrors   = map[string]lastError{}
+)
+
+// LastError returns the most recent error that the WithRestart or UntilSuccessful
+// families, or Retry, saw from the operation named opName and the time at which it
+// occurred.  The error is kept after the operation recovers, which makes it useful
+// for showing which operations have been flaky recently.  ok is false if no error
+// has been recorded for opName or it has been removed with Forget.
+//
+// Only the latest error is kept for each operation.
+func LastError(opName string) (err error, at time.Time, ok bool) {
+	lastErrorsMu.RLock()
+	defer lastErrorsMu.RUnlock()
+
+	e, ok := lastErrors[opName]
+	return e.err, e.at, ok
+}
+
+// Forget removes the error recorded for opName, for example once an operation has
+// been retired.
+func Forget(opName string) {
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+
+	delete(lastErrors, opName)
+}
+
+// setLastError records err as the most recent error of opName.
+func setLastError(opName string, err error) {
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+
+	lastErrors[opName] = lastError{err: err, at: currentClock().Now()}
+}
@@ -0,0 +1,40 @@
+package recovery
+
+import "context"
+
+// ConcurrencyLimiter limits the number of attempts that may run at the same time
+// across every operation that shares it.  When a dependency recovers, callers
+// that have been retrying against it would otherwise all make their next attempt
+// at once; a ConcurrencyLimiter spreads those attempts out.
+//
+// Pass a ConcurrencyLimiter to the retry helpers with WithConcurrencyLimiter.  A
+// ConcurrencyLimiter is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter that allows up to n attempts
+// to run at the same time.  An n of less than 1 is treated as 1.
+func NewConcurrencyLimiter(n int) *ConcurrencyLimiter {
+	if n < 1 {
+		n = 1
+	}
+	return &ConcurrencyLimiter{slots: make(chan struct{}, n)}
+}
+
+// Acquire blocks until an attempt may run or ctx is done, in which case it
+// returns ctx.Err().  Each successful call to Acquire must be matched by a call
+// to Release.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by a successful call to Acquire.
+func (l *ConcurrencyLimiter) Release() {
+	<-l.slots
+}
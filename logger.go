@@ -0,0 +1,209 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/yabosh/logger"
+)
+
+// Logger is the interface used by the recovery helpers to report panics,
+// restarts and retries.  Implement it to route the package's output into an
+// application's own logging pipeline.
+type Logger interface {
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// InfoLogger is an optional interface a Logger may implement to receive routine
+// messages, such as early retries when WithEscalateAfter is used.  Routine
+// messages are discarded by Loggers that do not implement it.  The default
+// logger writes them as warnings.
+type InfoLogger interface {
+	Info(format string, args ...interface{})
+}
+
+// ContextLogger is an optional interface a Logger may implement to receive the
+// context of the operation being logged, for example to attach request or tenant
+// IDs stored in it.  When the Logger implements ContextLogger its methods are
+// used in place of Warn, Error and Info.  The context is that passed to the
+// context-aware helpers, such as WithRestartContext, UntilSuccessfulContext and
+// HTTPMiddleware, and context.Background() otherwise.
+type ContextLogger interface {
+	InfoContext(ctx context.Context, format string, args ...interface{})
+	WarnContext(ctx context.Context, format string, args ...interface{})
+	ErrorContext(ctx context.Context, format string, args ...interface{})
+}
+
+// NopLogger is a Logger that discards every message.  Use SetLogger(NopLogger{})
+// to silence the package, for example in tests or when it is embedded in a
+// library, or WithLogger(NopLogger{}) to silence a single call.
+type NopLogger struct{}
+
+// Warn discards the message.
+func (NopLogger) Warn(format string, args ...interface{}) {}
+
+// Error discards the message.
+func (NopLogger) Error(format string, args ...interface{}) {}
+
+// defaultLogger forwards to github.com/yabosh/logger.
+type defaultLogger struct{}
+
+func (defaultLogger) Warn(format string, args ...interface{}) {
+	logger.Warn(format, args...)
+}
+
+func (defaultLogger) Error(format string, args ...interface{}) {
+	logger.Error(format, args...)
+}
+
+// Info writes routine messages as warnings, since github.com/yabosh/logger has
+// no info level, so that early retries under WithEscalateAfter are not lost.
+func (defaultLogger) Info(format string, args ...interface{}) {
+	logger.Warn(format, args...)
+}
+
+var (
+	loggerMu  sync.RWMutex
+	pkgLogger Logger = defaultLogger{}
+	pkgSlog   *slog.Logger
+)
+
+// SetLogger replaces the Logger used by the package.  Passing nil restores the
+// default logger.  SetLogger is safe to call concurrently with the helpers but is
+// typically called once during application startup.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = defaultLogger{}
+	}
+
+	loggerMu.Lock()
+	pkgLogger = l
+	loggerMu.Unlock()
+}
+
+// currentLogger returns the Logger most recently set with SetLogger.
+func currentLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return pkgLogger
+}
+
+// SetSlogHandler routes the package's panic and retry events to h as structured
+// slog records.  Each record carries the formatted message along with "op",
+// "attempt" and "error" attributes where they apply.  While a handler is set it
+// takes precedence over the Logger set with SetLogger; passing nil falls back to
+// that Logger again.  WithLogger still overrides both for a single call.
+func SetSlogHandler(h slog.Handler) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+
+	if h == nil {
+		pkgSlog = nil
+		return
+	}
+	pkgSlog = slog.New(h)
+}
+
+// currentSlog returns the slog.Logger built by SetSlogHandler, or nil.
+func currentSlog() *slog.Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return pkgSlog
+}
+
+// logWarn reports a warning about opName.  attempt and err are attached as
+// structured attributes when slog is in use and are omitted when zero.
+func (c *config) logWarn(opName string, attempt int, err error, format string, args ...interface{}) {
+	c.log(slog.LevelWarn, opName, attempt, err, format, args...)
+}
+
+// logRetry reports a routine retry or restart of opName.  It is logged as a
+// warning unless attempt is within the threshold set by WithEscalateAfter, in
+// which case it is logged at info level.
+func (c *config) logRetry(opName string, attempt int, err error, format string, args ...interface{}) {
+	level := slog.LevelWarn
+	if attempt <= c.escalateAfter {
+		level = slog.LevelInfo
+	}
+	c.log(level, opName, attempt, err, format, args...)
+}
+
+// logError reports an error about opName in the same way as logWarn.
+func (c *config) logError(opName string, attempt int, err error, format string, args ...interface{}) {
+	c.log(slog.LevelError, opName, attempt, err, format, args...)
+}
+
+func (c *config) log(level slog.Level, opName string, attempt int, err error, format string, args ...interface{}) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.slog == nil {
+		if l, ok := c.logger.(ContextLogger); ok {
+			switch {
+			case level >= slog.LevelError:
+				l.ErrorContext(ctx, format, args...)
+			case level >= slog.LevelWarn:
+				l.WarnContext(ctx, format, args...)
+			default:
+				l.InfoContext(ctx, format, args...)
+			}
+			return
+		}
+
+		switch {
+		case level >= slog.LevelError:
+			c.logger.Error(format, args...)
+		case level >= slog.LevelWarn:
+			c.logger.Warn(format, args...)
+		default:
+			if l, ok := c.logger.(InfoLogger); ok {
+				l.Info(format, args...)
+			}
+		}
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 3)
+	if opName != "" {
+		attrs = append(attrs, slog.String("op", opName))
+	}
+	if attempt > 0 {
+		attrs = append(attrs, slog.Int("attempt", attempt))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	c.slog.LogAttrs(ctx, level, fmt.Sprintf(format, args...), attrs...)
+}
+
+// logLimiter coalesces repeated log messages so that at most one is written per
+// interval.
+type logLimiter struct {
+	every      time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether a message should be written at now.  When it should,
+// suppressed is the number of messages skipped since the last one written and
+// since is the time that has passed since then.
+func (l *logLimiter) allow(now time.Time) (ok bool, suppressed int, since time.Duration) {
+	if l.every <= 0 {
+		return true, 0, 0
+	}
+	if !l.last.IsZero() && now.Sub(l.last) < l.every {
+		l.suppressed++
+		return false, 0, 0
+	}
+
+	suppressed, since = l.suppressed, now.Sub(l.last)
+	l.last = now
+	l.suppressed = 0
+	return true, suppressed, since
+}
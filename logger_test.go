@@ -0,0 +1,42 @@
+package recovery
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordingLogger is a Logger that keeps every formatted message.
+type recordingLogger struct {
+	mu     sync.Mutex
+	warns  []string
+	errors []string
+}
+
+func (l *recordingLogger) Warn(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Error(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+// useLogger installs l as the package Logger until the returned function is
+// called.
+func useLogger(l Logger) (restore func()) {
+	SetLogger(l)
+	return func() { SetLogger(nil) }
+}
+
+func TestDefaultLoggerKeepsInfoMessages(t *testing.T) {
+	SetLogger(nil)
+
+	cfg := newConfig([]Option{WithEscalateAfter(5)})
+	if _, ok := cfg.logger.(InfoLogger); !ok {
+		t.Fatalf("default logger %T does not implement InfoLogger, so early retries are discarded", cfg.logger)
+	}
+}
@@ -0,0 +1,58 @@
+package recovery
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// opMetrics holds the counters kept for a single operation.
+type opMetrics struct {
+	panics    int64
+	retries   int64
+	exhausted int64
+}
+
+var metrics sync.Map // map[string]*opMetrics
+
+// metricsFor returns the counters for opName, creating them if necessary.
+func metricsFor(opName string) *opMetrics {
+	if m, ok := metrics.Load(opName); ok {
+		return m.(*opMetrics)
+	}
+	m, _ := metrics.LoadOrStore(opName, &opMetrics{})
+	return m.(*opMetrics)
+}
+
+// loadMetrics returns the counters for opName without creating them.  An
+// operation with no recorded events reports zero for every counter.
+func loadMetrics(opName string) *opMetrics {
+	if m, ok := metrics.Load(opName); ok {
+		return m.(*opMetrics)
+	}
+	return &opMetrics{}
+}
+
+// PanicCount returns the number of panics that have been recovered for opName.
+func PanicCount(opName string) int64 {
+	return atomic.LoadInt64(&loadMetrics(opName).panics)
+}
+
+// RetryCount returns the number of times opName has been retried or restarted.
+func RetryCount(opName string) int64 {
+	return atomic.LoadInt64(&loadMetrics(opName).retries)
+}
+
+// ExhaustedCount returns the number of times a bounded retry or restart of
+// opName gave up because it ran out of attempts.
+func ExhaustedCount(opName string) int64 {
+	return atomic.LoadInt64(&loadMetrics(opName).exhausted)
+}
+
+// ResetMetrics clears the counters for every operation.  It is intended for use
+// in tests.
+func ResetMetrics() {
+	metrics.Range(func(key, _ interface{}) bool {
+		metrics.Delete(key)
+		return true
+	})
+}
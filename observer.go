@@ -0,0 +1,138 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// EventKind identifies the kind of an Event.
+type EventKind int
+
+const (
+	// EventPanic is observed each time a panic is recovered.
+	EventPanic EventKind = iota
+
+	// EventRetry is observed each time an operation fails and is about to be
+	// retried or restarted.
+	EventRetry
+
+	// EventSuccess is observed when a retried operation finally succeeds.
+	EventSuccess
+
+	// EventFailure is observed when a retried operation gives up without
+	// succeeding.
+	EventFailure
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventPanic:
+		return "panic"
+	case EventRetry:
+		return "retry"
+	case EventSuccess:
+		return "success"
+	case EventFailure:
+		return "failure"
+	}
+	return "unknown"
+}
+
+// Event describes something that happened while running an operation.
+type Event struct {
+	Kind EventKind
+
+	// Attempt is the number of the attempt the event relates to, starting at 1.
+	// For EventSuccess and EventFailure it is the total number of attempts made.
+	// It is 0 for an EventPanic.
+	Attempt int
+
+	// Err is the error associated with the event, if any.
+	Err error
+
+	// Elapsed is the time from the first attempt until the operation succeeded
+	// or gave up.  It is only set for EventSuccess and EventFailure.
+	Elapsed time.Duration
+
+	// Backoff is the part of Elapsed spent sleeping between attempts.  It is only
+	// set for EventSuccess and EventFailure.
+	Backoff time.Duration
+}
+
+// Observer receives the events produced by the recovery helpers.  It provides a
+// single place to hook up metrics, for example Prometheus counters of panics and
+// retries by operation and a histogram of Elapsed for EventSuccess, without this
+// package depending on a metrics library.
+//
+// Observe is called synchronously from the goroutine running the operation, so
+// it should return quickly.
+type Observer interface {
+	Observe(opName string, event Event)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface.
+type ObserverFunc func(opName string, event Event)
+
+// Observe calls f(opName, event).
+func (f ObserverFunc) Observe(opName string, event Event) {
+	f(opName, event)
+}
+
+var (
+	observersMu sync.RWMutex
+	observers   []Observer
+)
+
+// RegisterObserver adds o to the observers notified of every event.  Observers
+// are typically registered once during application startup.
+func RegisterObserver(o Observer) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+
+	observers = append(observers, o)
+}
+
+// observe notifies every registered Observer of event.
+func observe(opName string, event Event) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+
+	for _, o := range observers {
+		o.Observe(opName, event)
+	}
+}
+
+// RetryEvent is sent to a channel registered with WithEvents.
+type RetryEvent struct {
+	Op      string
+	Kind    EventKind
+	Attempt int
+	Err     error
+	Time    time.Time
+}
+
+// WithEvents registers ch to receive a RetryEvent for every panic, retry and
+// final outcome of an operation.  Sends never block: if ch is full the event is
+// dropped so that a slow consumer cannot stall the operation.  This complements
+// the Observer and hook callbacks and makes it easy to fan events out to several
+// sinks.
+func WithEvents(ch chan<- RetryEvent) Option {
+	return func(c *config) {
+		c.events = ch
+	}
+}
+
+// notify reports event to the registered observers and to the events channel,
+// if one is configured.
+func (c *config) notify(opName string, event Event) {
+	observe(opName, event)
+
+	if c.events == nil {
+		return
+	}
+
+	select {
+	case c.events <- RetryEvent{Op: opName, Kind: event.Kind, Attempt: event.Attempt, Err: event.Err, Time: currentClock().Now()}:
+	default:
+	}
+}
@@ -0,0 +1,484 @@
+package recovery
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultJitterMS is the jitter used between restarts and retries.
+	defaultJitterMS = 100
+
+	// defaultMaxBackoffMS is the longest pause between restarts and retries.
+	defaultMaxBackoffMS = 64000
+
+	// defaultStabilityThreshold is how long f() must run before WithRestart
+	// considers it to have succeeded and resets its backoff.
+	defaultStabilityThreshold = 60 * time.Second
+
+	// defaultMinRestartDelay is the safety floor on the pause before WithRestart
+	// restarts f().
+	defaultMinRestartDelay = 100 * time.Millisecond
+)
+
+// Option configures the optional behavior of the recovery helpers.
+type Option func(*config)
+
+// config holds the settings that can be changed through an Option.
+type config struct {
+	logger     Logger
+	slog       *slog.Logger
+	printStack bool
+	onPanic    func(opName string, value interface{}, stack []byte)
+	rethrowIf  func(value interface{}) bool
+
+	suppressStack bool
+
+	fatalRuntimeErrors bool
+
+	strategy   BackoffStrategy
+	fullJitter bool
+
+	jitterMS     int
+	minBackoffMS int
+	maxBackoffMS int
+
+	stabilityThreshold time.Duration
+	onReset            func(opName string)
+	onStateChange      func(opName string, healthy bool)
+	onStable           func(opName string, afterAttempts int)
+	panicOnly          bool
+	restartFullJitter  bool
+	restartOffset      time.Duration
+	logEvery           time.Duration
+	minRestartDelay    time.Duration
+	beforeRun          func(ctx context.Context) error
+	backoffIf          func(runtime time.Duration, err error, attempt int) bool
+
+	isRetryable   func(error) bool
+	maxElapsed    time.Duration
+	onRetry       func(opName string, attempt int, err error, next time.Duration)
+	onBackoff     func(opName string, attempt int, d time.Duration)
+	escalateAfter int
+	budget        *Budget
+	limiter       *ConcurrencyLimiter
+	events        chan<- RetryEvent
+	tracer        Tracer
+
+	// ctx is the context of the operation being run, passed on to a
+	// ContextLogger or slog handler.  It is set with withContext.
+	ctx context.Context
+}
+
+// newConfig returns a config with the package defaults and opts applied.
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		logger:       currentLogger(),
+		slog:         currentSlog(),
+		jitterMS:     defaultJitterMS,
+		maxBackoffMS: defaultMaxBackoffMS,
+
+		stabilityThreshold: defaultStabilityThreshold,
+		minRestartDelay:    defaultMinRestartDelay,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.strategy == nil {
+		cfg.strategy = NewExponential(cfg.jitterMS, cfg.maxBackoffMS)
+	}
+	return cfg
+}
+
+// withContext returns a copy of c that logs with ctx.  c itself is left
+// unchanged so that it can continue to be shared between calls.
+func (c *config) withContext(ctx context.Context) *config {
+	cp := *c
+	cp.ctx = ctx
+	return &cp
+}
+
+// restartDelay returns the pause to apply before restarting a service after
+// attempt quick failures, applying WithRestartFullJitter and WithRestartOffset.
+func (c *config) restartDelay(attempt int) time.Duration {
+	next := c.nextBackoff(attempt)
+	if c.restartFullJitter {
+		next = time.Duration(randFloat64() * float64(next))
+	}
+	if c.restartOffset > 0 {
+		next += time.Duration(processJitter() * float64(c.restartOffset))
+	}
+	if next < c.minRestartDelay {
+		next = c.minRestartDelay
+	}
+	return ceilBackoff(next)
+}
+
+// shouldBackoff reports whether WithRestart should back off after a run of f that
+// lasted runtime and failed with err, following attempt backed off runs.
+func (c *config) shouldBackoff(runtime time.Duration, err error, attempt int) bool {
+	if c.backoffIf != nil {
+		return c.backoffIf(runtime, err, attempt)
+	}
+	return runtime < c.stabilityThreshold
+}
+
+// nextBackoff returns the pause to apply before the next attempt after attempt
+// unsuccessful attempts, never less than the floor set with WithMinBackoff.  The
+// floor itself is limited to the maximum set with WithMaxBackoff.
+func (c *config) nextBackoff(attempt int) time.Duration {
+	d := c.strategy.Duration(attempt)
+	if floor := time.Duration(ClampBackoffMS(c.minBackoffMS, 0, c.maxBackoffMS)) * time.Millisecond; d < floor {
+		d = floor
+	}
+	return ceilBackoff(d)
+}
+
+// retryable reports whether err should cause the operation to be retried.
+// Errors marked with Permanent are never retried.
+func (c *config) retryable(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	return c.isRetryable == nil || c.isRetryable(err)
+}
+
+// retrying records a retry of opName, notifies any observers and invokes the
+// OnRetry hook, if one is registered.
+func (c *config) retrying(opName string, attempt int, err error, next time.Duration) {
+	atomic.AddInt64(&metricsFor(opName).retries, 1)
+	c.notify(opName, Event{Kind: EventRetry, Attempt: attempt, Err: err})
+
+	if c.onRetry != nil {
+		c.onRetry(opName, attempt, err, next)
+	}
+}
+
+// backingOff calls the hook set with WithOnBackoff, if any, before a pause of d.
+func (c *config) backingOff(opName string, attempt int, d time.Duration) {
+	if c.onBackoff != nil {
+		c.onBackoff(opName, attempt, d)
+	}
+}
+
+// WithPrintStack causes the stack trace of a recovered panic to also be
+// written to os.Stderr, as DontPanic did before stack traces were routed
+// through the logger.
+func WithPrintStack() Option {
+	return func(c *config) {
+		c.printStack = true
+	}
+}
+
+// WithoutStack prevents the stack trace of a recovered panic from being captured,
+// logged or printed.  The panic is still recovered and returned as a *PanicError,
+// but its Stack and Frames are empty.  It is intended for code that panics
+// deliberately to unwind, where the stack trace is only noise.
+func WithoutStack() Option {
+	return func(c *config) {
+		c.suppressStack = true
+	}
+}
+
+// WithOnPanic registers fn to be called whenever a panic is recovered.  value is
+// the recovered value and stack is the stack trace captured at the time.  fn is
+// called in addition to the normal logging, which makes it suitable for crash
+// reporting and metrics.
+func WithOnPanic(fn func(opName string, value interface{}, stack []byte)) Option {
+	return func(c *config) {
+		c.onPanic = fn
+	}
+}
+
+// WithRethrowIf registers a predicate that is consulted whenever a panic is
+// recovered.  If fn returns true for the recovered value then the panic is logged
+// and reported as usual and then re-raised with the original value, for example
+// to let genuine programming errors crash the process.  By default every panic is
+// recovered.
+func WithRethrowIf(fn func(value interface{}) bool) Option {
+	return func(c *config) {
+		c.rethrowIf = fn
+	}
+}
+
+// WithFatalRuntimeErrors causes panics whose value is a runtime.Error to be
+// logged and then re-raised instead of recovered.  These are the panics raised by
+// the runtime itself, such as a nil pointer dereference, an index out of range, a
+// failed type assertion or an integer division by zero.  They indicate a bug, and
+// recovering from them can hide state corrupted by the code that panicked.
+//
+// Conditions such as concurrent map writes, running out of memory or a stack
+// overflow are fatal errors in Go and terminate the process without a panic, so
+// they are never seen by this package.  By default every panic is recovered.
+func WithFatalRuntimeErrors() Option {
+	return func(c *config) {
+		c.fatalRuntimeErrors = true
+	}
+}
+
+// WithLogger overrides the package Logger for a single call.
+func WithLogger(l Logger) Option {
+	return func(c *config) {
+		if l != nil {
+			c.logger = l
+			c.slog = nil
+		}
+	}
+}
+
+// WithBackoff sets the BackoffStrategy used to pause between restarts or
+// retries.  The default is NewExponential(100, 64000), which can also be tuned
+// with WithJitter and WithMaxBackoff.
+func WithBackoff(s BackoffStrategy) Option {
+	return func(c *config) {
+		if s != nil {
+			c.strategy = s
+		}
+	}
+}
+
+// WithJitter sets the milliseconds of jitter added by the default exponential
+// backoff.  The default is 100.  It has no effect when WithBackoff is used.
+func WithJitter(ms int) Option {
+	return func(c *config) {
+		c.jitterMS = ms
+	}
+}
+
+// WithMinBackoff sets the shortest pause, in milliseconds, between attempts.  The
+// floor applies to every BackoffStrategy, including those whose first delay is 0,
+// and to Backoff, which clamps its result to [ms, maxMS].  This guarantees a
+// minimum spacing between calls to a rate-limited endpoint.  If ms is greater
+// than the maximum set with WithMaxBackoff, or passed to Backoff, then the maximum
+// wins, as it does for ClampBackoffMS.  The default is 0.
+func WithMinBackoff(ms int) Option {
+	return func(c *config) {
+		c.minBackoffMS = ms
+	}
+}
+
+// WithMaxBackoff sets the longest pause, in milliseconds, of the default
+// exponential backoff.  The default is 64000.  It has no effect when WithBackoff
+// is used.
+func WithMaxBackoff(ms int) Option {
+	return func(c *config) {
+		c.maxBackoffMS = ms
+	}
+}
+
+// WithFullJitter causes Backoff to use FullJitterBackoffMS rather than adding
+// a fixed amount of jitter to the exponential value.
+func WithFullJitter() Option {
+	return func(c *config) {
+		c.fullJitter = true
+	}
+}
+
+// WithStabilityThreshold sets how long f() must run before WithRestart treats
+// the run as a success and resets its attempt counter.  Runs shorter than d are
+// followed by a backoff before f() is restarted.  The default is 60 seconds.
+func WithStabilityThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.stabilityThreshold = d
+	}
+}
+
+// WithShouldBackoff replaces the stability threshold test that WithRestart uses
+// to decide whether to back off before restarting f.  fn is called after each
+// failed run with how long the run lasted, the error it failed with and the
+// number of consecutive runs that have already been backed off.  If fn returns
+// true then WithRestart backs off as it does after a short run; otherwise it
+// restarts f immediately and resets its attempt counter as it does after a long
+// run.
+//
+// For example, to back off on every rate limit error but restart immediately on
+// anything else that ran for at least a minute:
+//
+//	recovery.WithShouldBackoff(func(runtime time.Duration, err error, attempt int) bool {
+//		return errors.Is(err, errRateLimited) || runtime < time.Minute
+//	})
+func WithShouldBackoff(fn func(runtime time.Duration, err error, attempt int) bool) Option {
+	return func(c *config) {
+		c.backoffIf = fn
+	}
+}
+
+// WithMinRestartDelay sets the safety floor on how quickly WithRestart restarts
+// f.  Every backoff is at least d, and a run that fails after less than d is
+// followed by a pause that makes up the difference even when no backoff applies.
+// This stops a misconfigured backoff from turning an f that fails instantly into
+// a loop that spins the CPU.  The default is 100 milliseconds; a d of 0 removes
+// the floor.
+func WithMinRestartDelay(d time.Duration) Option {
+	return func(c *config) {
+		c.minRestartDelay = d
+	}
+}
+
+// WithOnReset registers fn to be called by WithRestart whenever the attempt
+// counter is reset after f() has run longer than the stability threshold.  fn is
+// called from the goroutine running WithRestart.
+func WithOnReset(fn func(opName string)) Option {
+	return func(c *config) {
+		c.onReset = fn
+	}
+}
+
+// WithIsRetryable sets a predicate used to classify errors returned by f.  When
+// fn returns false the error is treated as permanent: the retry or restart loop
+// stops and the error is returned.  Errors marked with Permanent are never
+// retried regardless of fn.
+func WithIsRetryable(fn func(error) bool) Option {
+	return func(c *config) {
+		c.isRetryable = fn
+	}
+}
+
+// WithOnRetry registers fn to be called each time an operation fails and is about
+// to be retried or restarted.  attempt is the number of the attempt that failed,
+// starting at 1, err is the failure and next is the backoff that will be applied
+// before the next attempt.  fn is called just before the backoff begins.
+func WithOnRetry(fn func(opName string, attempt int, err error, next time.Duration)) Option {
+	return func(c *config) {
+		c.onRetry = fn
+	}
+}
+
+// WithOnBackoff registers fn to be called with every pause just before it starts.
+// It is called by Backoff and BackoffContext, where opName is empty, and by the
+// WithRestart and UntilSuccessful families and Retry before they sleep between
+// attempts.  attempt is the number of unsuccessful attempts so far.  Unlike
+// WithOnRetry it reports only the pauses themselves, which makes it suitable for
+// recording a histogram of backoff durations.
+func WithOnBackoff(fn func(opName string, attempt int, d time.Duration)) Option {
+	return func(c *config) {
+		c.onBackoff = fn
+	}
+}
+
+// WithMaxElapsedTime limits the total time the UntilSuccessful family spends
+// retrying an operation.  Before each backoff the helpers check whether the time
+// elapsed so far plus the coming backoff would exceed d and, if so, give up and
+// return the last error rather than sleep past the budget.  A d of 0 means no
+// limit.
+func WithMaxElapsedTime(d time.Duration) Option {
+	return func(c *config) {
+		c.maxElapsed = d
+	}
+}
+
+// WithStateChange registers fn to be called by WithRestart when the health of
+// the task changes.  fn is called with healthy set to false when f() fails quickly
+// and WithRestart begins backing off, and with healthy set to true once a later run
+// of f() has lasted longer than the stability threshold.  fn is only called on a
+// transition and calls are serialized, but fn may be called from a goroutine other
+// than the one running WithRestart.
+func WithStateChange(fn func(opName string, healthy bool)) Option {
+	return func(c *config) {
+		c.onStateChange = fn
+	}
+}
+
+// WithOnStable registers fn to be called by WithRestart when a task that has been
+// failing recovers, that is when a run of f() that follows one or more failed
+// runs lasts longer than the stability threshold.  afterAttempts is the number of
+// consecutive failed runs that preceded the recovery.  fn is called once per
+// recovery, at the moment the threshold is reached, which makes it the natural
+// place to log the recovery or clear an alert.  Like the callback of
+// WithStateChange it may be called from a goroutine other than the one running
+// WithRestart.
+func WithOnStable(fn func(opName string, afterAttempts int)) Option {
+	return func(c *config) {
+		c.onStable = fn
+	}
+}
+
+// WithRestartOnPanicOnly causes WithRestart to restart f only when it panics.  A
+// non-nil error returned by f is treated as f finishing: the loop ends and the
+// error is returned to the caller.  By default f is restarted after both panics
+// and returned errors.
+func WithRestartOnPanicOnly() Option {
+	return func(c *config) {
+		c.panicOnly = true
+	}
+}
+
+// withBeforeRun registers fn to be called by WithRestart before each run of f(),
+// ahead of the run being timed.  If fn returns an error then WithRestart stops
+// and returns it.  It is used by Supervisor to hold paused workers.
+func withBeforeRun(fn func(ctx context.Context) error) Option {
+	return func(c *config) {
+		c.beforeRun = fn
+	}
+}
+
+// WithRestartFullJitter causes WithRestart to pause for a random duration
+// between zero and the computed backoff before restarting f, rather than for the
+// backoff plus a small amount of jitter.  It spreads restarts out much further,
+// which matters when many instances fail together because of a shared
+// dependency and would otherwise all restart in lockstep.
+func WithRestartFullJitter() Option {
+	return func(c *config) {
+		c.restartFullJitter = true
+	}
+}
+
+// WithRestartOffset adds a fixed offset between zero and maxOffset to every
+// restart delay of WithRestart.  The offset is chosen at random once per process,
+// so it is the same for every restart within the process but differs between
+// instances of a service.
+//
+// For a fleet of instances that share a dependency, combining the two is
+// recommended so that recovery is spread across the fleet:
+//
+//	recovery.WithRestart("consumer", consume,
+//		recovery.WithRestartFullJitter(),
+//		recovery.WithRestartOffset(5*time.Second))
+func WithRestartOffset(maxOffset time.Duration) Option {
+	return func(c *config) {
+		c.restartOffset = maxOffset
+	}
+}
+
+// WithEscalateAfter quiets the log during transient failures.  The first n
+// retries or restarts of an operation are logged at info level, and only later
+// ones are logged as warnings.  Info messages are only written by a Logger that
+// implements InfoLogger, or when a slog handler is set.  The default logger has
+// no info level and writes them as warnings.  The default of 0 logs every retry
+// as a warning.
+func WithEscalateAfter(n int) Option {
+	return func(c *config) {
+		c.escalateAfter = n
+	}
+}
+
+// WithLogEvery limits WithRestart to logging at most one "Restarting service"
+// message every d.  Restarts in between are counted rather than logged, and the
+// next message that is written reports how many restarts occurred since the
+// previous one.  This keeps the log volume bounded while a worker is flapping
+// during a prolonged incident.  Panics are still logged as they occur.  The
+// default of 0 logs every restart.
+func WithLogEvery(d time.Duration) Option {
+	return func(c *config) {
+		c.logEvery = d
+	}
+}
+
+// WithBudget makes each retry consume a retry from b.  When b is exhausted the
+// UntilSuccessful family and Retry stop retrying and return the last error.
+func WithBudget(b *Budget) Option {
+	return func(c *config) {
+		c.budget = b
+	}
+}
+
+// WithConcurrencyLimiter makes each attempt of the UntilSuccessful family and
+// Retry wait for a slot from l before it runs.  While waiting the attempt still
+// honors the cancellation of a context passed to the helper.
+func WithConcurrencyLimiter(l *ConcurrencyLimiter) Option {
+	return func(c *config) {
+		c.limiter = l
+	}
+}
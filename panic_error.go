@@ -0,0 +1,147 @@
+package recovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ErrPanic matches any *PanicError with errors.Is, which gives a simple way to
+// check whether an operation failed because it panicked regardless of the value
+// it panicked with.
+var ErrPanic = errors.New("recovered panic")
+
+// PanicError is returned by DontPanic when the function it wraps panics.  It
+// retains the value passed to panic() along with the stack trace of the
+// goroutine at the time the panic was recovered.
+//
+// When the recovered value is itself an error it is part of the error chain, so
+// errors.As can extract both the *PanicError and the original error type:
+//
+//	err := recovery.DontPanic("parse", parse)
+//	var syntaxErr *SyntaxError
+//	if errors.As(err, &syntaxErr) {
+//		// parse panicked with a *SyntaxError
+//	}
+//
+// A recovered value that is not an error, such as a string or a plain struct, is
+// available from Value after extracting the *PanicError.
+type PanicError struct {
+	// Value is the value that was recovered from the panic.
+	Value interface{}
+
+	// Stack is the stack trace captured when the panic was recovered.
+	Stack []byte
+
+	// Frames is the symbolized call stack at the point of the panic with the
+	// frames belonging to this package and to the runtime's panic machinery
+	// removed.
+	Frames []runtime.Frame
+
+	// GoroutineID is the ID of the goroutine that panicked, or 0 if it could
+	// not be determined.
+	GoroutineID uint64
+}
+
+// PanicFormatter formats the recovered value of a PanicError into its error
+// message.  The default uses "%#v" for compatibility with earlier releases, which
+// quotes string panics; set it to one using "%v" for plainer messages:
+//
+//	recovery.PanicFormatter = func(value interface{}) string {
+//		return fmt.Sprintf("%v", value)
+//	}
+//
+// It is typically set once during application startup.
+var PanicFormatter = func(value interface{}) string {
+	return fmt.Sprintf("%#v", value)
+}
+
+// Error formats the recovered value using PanicFormatter.
+func (e *PanicError) Error() string {
+	if PanicFormatter == nil {
+		return fmt.Sprintf("%#v", e.Value)
+	}
+	return PanicFormatter(e.Value)
+}
+
+// Unwrap returns the recovered value if it is an error so that errors.Is and
+// errors.As can be used to inspect the cause of the panic.  The recovered error is
+// returned as is, so a chain built with fmt.Errorf and %w before the panic is
+// preserved and errors.Is can reach its root cause.  The retry helpers keep the
+// chain intact when they wrap the final failure.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Is reports whether target is ErrPanic.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
+// StackString returns Frames as a compact listing with one "function (file:line)"
+// entry per line.
+func (e *PanicError) StackString() string {
+	var b strings.Builder
+	for _, frame := range e.Frames {
+		fmt.Fprintf(&b, "%s (%s:%d)\n", frame.Function, frame.File, frame.Line)
+	}
+	return b.String()
+}
+
+// packagePrefix is the prefix shared by the names of every function in this
+// package, e.g. "github.com/yabosh/recovery.".
+var packagePrefix = reflect.TypeOf(PanicError{}).PkgPath() + "."
+
+// captureFrames returns the frames of the calling goroutine's stack, skipping
+// frames from this package and the runtime functions that implement panic.
+func captureFrames() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var result []runtime.Frame
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) && !isPanicFrame(frame.Function) {
+			result = append(result, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	return result
+}
+
+// isPanicFrame reports whether function is part of the runtime's panic handling.
+func isPanicFrame(function string) bool {
+	return function == "runtime.gopanic" ||
+		function == "runtime.sigpanic" ||
+		strings.HasPrefix(function, "runtime.panic")
+}
+
+// goroutineID parses the goroutine ID from the first line of a stack trace
+// produced by debug.Stack, which has the form "goroutine 18 [running]:".
+func goroutineID(stack []byte) uint64 {
+	line := stack
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := bytes.Fields(line)
+	if len(fields) < 2 || string(fields[0]) != "goroutine" {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
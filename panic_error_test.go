@@ -0,0 +1,70 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// validationError is a custom error type used to test errors.As.
+type validationError struct {
+	field string
+}
+
+func (e *validationError) Error() string {
+	return "invalid " + e.field
+}
+
+func TestPanicErrorAs(t *testing.T) {
+	defer useLogger(NopLogger{})()
+
+	err := DontPanic("validate", func() error {
+		panic(&validationError{field: "email"})
+	})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("errors.As(%v, *PanicError) = false, want true", err)
+	}
+	var valErr *validationError
+	if !errors.As(err, &valErr) {
+		t.Fatalf("errors.As(%v, *validationError) = false, want true", err)
+	}
+	if valErr.field != "email" {
+		t.Errorf("extracted field = %q, want %q", valErr.field, "email")
+	}
+}
+
+func TestPanicFormatter(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	defer func(f func(interface{}) string) { PanicFormatter = f }(PanicFormatter)
+
+	panicky := func() error { panic("disk full") }
+
+	if got := DontPanic("format", panicky).Error(); got != `"disk full"` {
+		t.Errorf("default message = %s, want %q", got, `"disk full"`)
+	}
+
+	PanicFormatter = func(value interface{}) string {
+		return fmt.Sprintf("%v", value)
+	}
+	if got := DontPanic("format", panicky).Error(); got != "disk full" {
+		t.Errorf("message with a %%v formatter = %q, want %q", got, "disk full")
+	}
+}
+
+func TestPanicErrorPreservesWrappedChain(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	cause := errors.New("connection reset")
+
+	panicky := func() error {
+		panic(fmt.Errorf("reading config: %w", cause))
+	}
+
+	if err := DontPanic("chain", panicky); !errors.Is(err, cause) {
+		t.Errorf("errors.Is(DontPanic error, cause) = false for %v, want true", err)
+	}
+	if err := Retry("chain", 1, panicky); !errors.Is(err, cause) {
+		t.Errorf("errors.Is(Retry error, cause) = false for %v, want true", err)
+	}
+}
@@ -0,0 +1,35 @@
+package recovery
+
+import "errors"
+
+// PermanentError marks an error as terminal.  The retry and restart helpers stop
+// immediately when f returns a PermanentError instead of retrying an operation
+// that cannot succeed, such as one that failed because of bad credentials.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that was marked as permanent.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so that the retry and restart helpers will not retry it.
+// Permanent returns nil if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, was marked with
+// Permanent.
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,59 @@
+package recovery
+
+import "context"
+
+// Policy is a reusable retry policy: a maximum number of attempts together with
+// the options, such as the backoff strategy, the retryable predicate and hooks,
+// that control what happens when an operation fails.  Defining a Policy once and
+// sharing it separates what to do on failure from the operations themselves.
+//
+// Sample usage:
+//
+//	var dbPolicy = recovery.NewPolicy(5,
+//		recovery.WithBackoff(recovery.NewExponential(250, 10000)),
+//		recovery.WithIsRetryable(isTransient))
+//	...
+//	err := dbPolicy.Do("save-order", func() error {
+//		return db.Save(order)
+//	})
+//
+// Retry, RetryContext and RetryValue are shorthands for a Policy used once.  A
+// Policy is safe for concurrent use.
+type Policy struct {
+	maxAttempts int
+	opts        []Option
+}
+
+// NewPolicy returns a Policy that attempts an operation up to maxAttempts times
+// using opts.  A maxAttempts of 0 means that the operation is retried
+// indefinitely.
+func NewPolicy(maxAttempts int, opts ...Option) *Policy {
+	return &Policy{maxAttempts: maxAttempts, opts: opts}
+}
+
+// Do runs f under the policy in the same way as Retry.
+func (p *Policy) Do(opName string, f func() error) error {
+	return p.DoContext(context.Background(), opName, ignoreContext(f))
+}
+
+// DoContext runs f under the policy in the same way as RetryContext.
+func (p *Policy) DoContext(ctx context.Context, opName string, f func(context.Context) error) error {
+	_, _, err := retryLoop(ctx, newConfig(p.opts), opName, p.maxAttempts, f)
+	return err
+}
+
+// DoValue runs f under p in the same way as RetryValue.  It is a function rather
+// than a method of Policy because Go methods cannot have type parameters.
+func DoValue[T any](p *Policy, opName string, f func() (T, error)) (T, error) {
+	var result T
+	err := p.Do(opName, func() error {
+		v, err := f()
+		if err != nil {
+			return err
+		}
+		result = v
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,54 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+)
+
+// Pool runs a fixed number of identical workers, each with the restart and
+// backoff behavior of WithRestart.  A worker that fails or panics is restarted on
+// its own; the other workers in the pool are unaffected.
+//
+// Sample usage:
+//
+//	pool := NewPool(8, "consumer", func(ctx context.Context) error {
+//		for {
+//			select {
+//			case work := <-workQueue:
+//				// Process 'work'
+//			case <-ctx.Done():
+//				return nil
+//			}
+//		}
+//	})
+//	...
+//	err := pool.Shutdown(ctx)
+type Pool struct {
+	sup *Supervisor
+}
+
+// NewPool starts size workers that each run worker.  The workers are named
+// opName-0 through opName-(size-1) in the log.  The context passed to worker is
+// cancelled when the pool is shut down.
+func NewPool(size int, opName string, worker func(ctx context.Context) error, opts ...Option) *Pool {
+	sup := NewSupervisor(opts...)
+	for i := 0; i < size; i++ {
+		sup.AddContext(fmt.Sprintf("%s-%d", opName, i), worker)
+	}
+	sup.Start()
+
+	return &Pool{sup: sup}
+}
+
+// Running returns the number of workers that are currently running or waiting
+// to be restarted.
+func (p *Pool) Running() int {
+	return p.sup.Running()
+}
+
+// Shutdown stops restarting workers and waits for them to drain.  If ctx is done
+// before every worker has returned then the error names the workers that are
+// still running, as described for Supervisor.Shutdown.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	return p.sup.Shutdown(ctx)
+}
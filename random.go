@@ -0,0 +1,64 @@
+package recovery
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// randGen is the source set with SetRandSource, or nil to use the global
+	// functions in math/rand.  It is loaded atomically so that the default path
+	// takes no lock of its own; randMu is only held while using a custom
+	// *rand.Rand, which is not safe for concurrent use.
+	randMu  sync.Mutex
+	randGen atomic.Pointer[rand.Rand]
+
+	processJitterOnce sync.Once
+	processJitterVal  float64
+)
+
+// SetRandSource sets the source of randomness used to compute jitter.  Seeding
+// a source, for example rand.NewSource(1), makes the jitter reproducible which is
+// useful in tests.  Passing nil restores the default of using the global
+// functions in math/rand.
+func SetRandSource(src rand.Source) {
+	if src == nil {
+		randGen.Store(nil)
+		return
+	}
+	randGen.Store(rand.New(src))
+}
+
+// randIntn returns a random int in [0, n) from the configured source.
+func randIntn(n int) int {
+	r := randGen.Load()
+	if r == nil {
+		return rand.Intn(n)
+	}
+
+	randMu.Lock()
+	defer randMu.Unlock()
+	return r.Intn(n)
+}
+
+// randFloat64 returns a random float64 in [0.0, 1.0) from the configured source.
+func randFloat64() float64 {
+	r := randGen.Load()
+	if r == nil {
+		return rand.Float64()
+	}
+
+	randMu.Lock()
+	defer randMu.Unlock()
+	return r.Float64()
+}
+
+// processJitter returns a random float64 in [0.0, 1.0) that is chosen on first
+// use and then stays the same for the life of the process.
+func processJitter() float64 {
+	processJitterOnce.Do(func() {
+		processJitterVal = randFloat64()
+	})
+	return processJitterVal
+}
@@ -0,0 +1,41 @@
+package recovery
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestSetRandSourceReproducible(t *testing.T) {
+	defer SetRandSource(nil)
+
+	sample := func() []int {
+		SetRandSource(rand.NewSource(1))
+		var jitter []int
+		for attempt := 0; attempt < 10; attempt++ {
+			jitter = append(jitter, ExponentialBackoffMS(attempt, 1000, 64000))
+		}
+		return jitter
+	}
+
+	first, second := sample(), sample()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("attempt %d: got %d then %d from the same seed", i, first[i], second[i])
+		}
+	}
+}
+
+func TestRestartDelayWithoutOffsetKeepsSeededSequence(t *testing.T) {
+	defer SetRandSource(nil)
+	processJitterOnce = sync.Once{}
+
+	SetRandSource(rand.NewSource(1))
+	want := randIntn(1000)
+
+	SetRandSource(rand.NewSource(1))
+	newConfig([]Option{WithBackoff(NewConstant(500))}).restartDelay(0)
+	if got := randIntn(1000); got != want {
+		t.Errorf("draw after restartDelay = %d, want %d as if no value had been taken", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package recovery
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WaitForSignal blocks until one of sigs is received or ctx is done.  It returns
+// the signal that was received, or ctx.Err() if ctx was done first.  If no signals
+// are given then it waits for SIGINT or SIGTERM.
+func WaitForSignal(ctx context.Context, sigs ...os.Signal) (os.Signal, error) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+
+	select {
+	case sig := <-ch:
+		return sig, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RunUntilSignal starts the Supervisor, waits until one of sigs is received and
+// then shuts the Supervisor down, waiting for every worker to drain.  If no
+// signals are given then it waits for SIGINT or SIGTERM.  It returns the result of
+// Shutdown.
+//
+// Sample usage:
+//
+//	func main() {
+//		sup := recovery.NewSupervisor()
+//		sup.AddContext("consumer", consume)
+//		if err := sup.RunUntilSignal(); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func (s *Supervisor) RunUntilSignal(sigs ...os.Signal) error {
+	s.Start()
+
+	sig, _ := WaitForSignal(context.Background(), sigs...)
+	newConfig(s.opts).logWarn("", 0, nil, "Received %s, shutting down supervised workers", sig)
+
+	return s.Shutdown(context.Background())
+}
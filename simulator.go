@@ -0,0 +1,69 @@
+package recovery
+
+import "time"
+
+// Simulator predicts how a retry configuration behaves for a given pattern of
+// failures without sleeping.  It applies the same backoff computation, attempt
+// limit and WithMaxElapsedTime budget as Retry and the UntilSuccessful family, so
+// it can be used to check offline that a configuration stays within a time budget.
+//
+// Jitter is applied exactly as it would be at run time; use WithJitter(0) or
+// SetRandSource for a repeatable simulation.
+type Simulator struct {
+	cfg *config
+
+	// AttemptDuration is the simulated time taken by each attempt of the
+	// operation itself.  It defaults to 0.
+	AttemptDuration time.Duration
+}
+
+// SimulationResult is the outcome of Simulator.Run.
+type SimulationResult struct {
+	// Attempts is the number of attempts that were made.
+	Attempts int
+
+	// Succeeded reports whether the final attempt succeeded.
+	Succeeded bool
+
+	// Elapsed is the total simulated time, including both the attempts and the
+	// backoff between them.
+	Elapsed time.Duration
+
+	// Backoff is the portion of Elapsed spent in backoff.
+	Backoff time.Duration
+}
+
+// NewSimulator returns a Simulator for the retry configuration described by opts.
+func NewSimulator(opts ...Option) *Simulator {
+	return &Simulator{cfg: newConfig(opts)}
+}
+
+// Run simulates retrying an operation up to maxAttempts times, where succeeds
+// reports whether the attempt with the given number, counting from 1, succeeds.
+// A maxAttempts of 0 means no limit, in which case succeeds must eventually
+// return true or a WithMaxElapsedTime budget must be configured.
+func (s *Simulator) Run(maxAttempts int, succeeds func(attempt int) bool) SimulationResult {
+	var result SimulationResult
+
+	for attempt := 0; ; attempt++ {
+		result.Attempts = attempt + 1
+		result.Elapsed += s.AttemptDuration
+
+		if succeeds(attempt + 1) {
+			result.Succeeded = true
+			return result
+		}
+
+		if maxAttempts > 0 && attempt+1 >= maxAttempts {
+			return result
+		}
+
+		next := s.cfg.nextBackoff(attempt)
+		if s.cfg.maxElapsed > 0 && result.Elapsed+next > s.cfg.maxElapsed {
+			return result
+		}
+
+		result.Elapsed += next
+		result.Backoff += next
+	}
+}
@@ -0,0 +1,37 @@
+package recovery
+
+import "sync"
+
+// taskStatus is the state of a task run by WithRestart as reported by Status.
+type taskStatus struct {
+	consecutiveFailures int
+	inBackoff           bool
+}
+
+var (
+	statusMu sync.RWMutex
+	statuses = map[string]taskStatus{}
+)
+
+// Status reports the state of the task named opName that is, or was, run by the
+// WithRestart family.  consecutiveFailures is the number of times in a row the
+// task has failed since it last ran longer than its stability threshold and
+// inBackoff reports whether it is currently waiting to be restarted.  ok is false
+// if no task named opName has been run.
+//
+// Status is safe to call concurrently with the tasks it reports on.
+func Status(opName string) (consecutiveFailures int, inBackoff bool, ok bool) {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+
+	s, ok := statuses[opName]
+	return s.consecutiveFailures, s.inBackoff, ok
+}
+
+// setStatus records the current state of the task named opName.
+func setStatus(opName string, consecutiveFailures int, inBackoff bool) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	statuses[opName] = taskStatus{consecutiveFailures: consecutiveFailures, inBackoff: inBackoff}
+}
@@ -0,0 +1,152 @@
+package recovery
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy determines how long to pause before the next attempt of an
+// operation that has failed.  attempt is the number of unsuccessful attempts
+// that have occurred so far, starting at 0.
+type BackoffStrategy interface {
+	Duration(attempt int) time.Duration
+}
+
+// exponential implements the package's original exponential backoff formula.
+type exponential struct {
+	baseMS   int
+	jitterMS int
+	maxMS    int
+}
+
+// NewExponential returns a BackoffStrategy that doubles the delay with each
+// attempt, starting at one second, and adds up to jitterMS milliseconds of
+// randomness.  A jitterMS of 0 or less disables the random component.  The delay
+// never exceeds maxMS milliseconds.
+func NewExponential(jitterMS int, maxMS int) BackoffStrategy {
+	return exponential{baseMS: defaultBaseMS, jitterMS: jitterMS, maxMS: maxMS}
+}
+
+func (e exponential) Duration(attempt int) time.Duration {
+	var randomMs float64
+	if e.jitterMS > 0 {
+		randomMs = float64(randIntn(e.jitterMS))
+	}
+	return time.Duration(exponentialDelayMS(attempt, e.baseMS, randomMs, e.maxMS)) * time.Millisecond
+}
+
+// defaultBaseMS is the delay of the first attempt in the exponential formula.
+const defaultBaseMS = 1000
+
+// exponentialDelayMS is the formula behind NewExponential and ExponentialBackoffMS
+// with the random component supplied by the caller.
+func exponentialDelayMS(attempt int, baseMS int, randomMs float64, maxMS int) int {
+	return int(math.Min(math.Pow(2, float64(clampExponent(attempt)))*float64(baseMS)+randomMs, float64(maxMS)))
+}
+
+// maxExponent is the largest power of two used by the exponential formulas.
+// Any practical maximum delay is reached long before this point, and limiting
+// the exponent keeps every intermediate value finite and well inside the range
+// that can be converted back to an int.
+const maxExponent = 52
+
+// clampExponent limits attempt to the range [0, maxExponent].
+func clampExponent(attempt int) int {
+	if attempt < 0 {
+		return 0
+	}
+	if attempt > maxExponent {
+		return maxExponent
+	}
+	return attempt
+}
+
+// linear increases the delay by a fixed step with each attempt.
+type linear struct {
+	stepMS int
+	maxMS  int
+}
+
+// NewLinear returns a BackoffStrategy whose delay grows by stepMS milliseconds
+// with each attempt.  The delay never exceeds maxMS milliseconds.
+func NewLinear(stepMS int, maxMS int) BackoffStrategy {
+	return linear{stepMS: stepMS, maxMS: maxMS}
+}
+
+func (l linear) Duration(attempt int) time.Duration {
+	ms := attempt * l.stepMS
+	if ms > l.maxMS {
+		ms = l.maxMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// constant always pauses for the same amount of time, plus optional jitter.
+type constant struct {
+	intervalMS int
+	jitterMS   int
+}
+
+// NewConstant returns a BackoffStrategy that always pauses for intervalMS
+// milliseconds regardless of the number of attempts.
+func NewConstant(intervalMS int) BackoffStrategy {
+	return constant{intervalMS: intervalMS}
+}
+
+func (c constant) Duration(int) time.Duration {
+	ms := c.intervalMS
+	if c.jitterMS > 0 {
+		ms += randIntn(c.jitterMS)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// DecorrelatedJitter implements the "decorrelated jitter" backoff algorithm in
+// which each delay is chosen at random between the base delay and three times
+// the previous delay, capped at a maximum:
+//
+//	sleep = min(cap, random_between(base, prev * 3))
+//
+// Because each delay depends on the one before it a DecorrelatedJitter carries
+// state between attempts.  Call Reset once the operation succeeds so that the
+// next failure starts again from the base delay rather than from a stale, large
+// one.  It is safe for concurrent use.
+type DecorrelatedJitter struct {
+	mu     sync.Mutex
+	baseMS int
+	maxMS  int
+	prevMS int
+}
+
+// NewDecorrelatedJitter returns a DecorrelatedJitter whose delays are never
+// shorter than baseMS or longer than maxMS milliseconds.
+func NewDecorrelatedJitter(baseMS int, maxMS int) *DecorrelatedJitter {
+	return &DecorrelatedJitter{baseMS: baseMS, maxMS: maxMS, prevMS: baseMS}
+}
+
+// Next returns the next delay in the sequence.
+func (d *DecorrelatedJitter) Next() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ms := d.baseMS
+	if upper := d.prevMS * 3; upper > d.baseMS {
+		ms += randIntn(upper - d.baseMS + 1)
+	}
+	if ms > d.maxMS {
+		ms = d.maxMS
+	}
+	d.prevMS = ms
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Reset discards the delay carried over from previous attempts so that the
+// sequence starts again from the base delay, as it did when d was created.
+func (d *DecorrelatedJitter) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prevMS = d.baseMS
+}
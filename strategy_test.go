@@ -0,0 +1,37 @@
+package recovery
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestDecorrelatedJitterReset(t *testing.T) {
+	defer SetRandSource(nil)
+
+	sequence := func(d *DecorrelatedJitter) []time.Duration {
+		SetRandSource(rand.NewSource(1))
+		var delays []time.Duration
+		for i := 0; i < 5; i++ {
+			delays = append(delays, d.Next())
+		}
+		return delays
+	}
+
+	d := NewDecorrelatedJitter(100, 100000)
+	initial := sequence(d)
+	for i := 0; i < 20; i++ {
+		d.Next()
+	}
+	d.Reset()
+	afterReset := sequence(d)
+
+	for i := range initial {
+		if initial[i] != afterReset[i] {
+			t.Fatalf("delay %d after Reset = %s, want %s as for a new DecorrelatedJitter", i, afterReset[i], initial[i])
+		}
+	}
+	if first := afterReset[0]; first < 100*time.Millisecond || first > 300*time.Millisecond {
+		t.Errorf("first delay after Reset = %s, want within [100ms, 300ms]", first)
+	}
+}
@@ -0,0 +1,248 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Supervisor manages a group of long running workers, each of which is run with
+// the same restart and backoff behavior as WithRestart.  A worker that fails or
+// panics is restarted on its own without affecting the other workers, in the
+// style of an Erlang one-for-one supervisor.
+//
+// Sample usage:
+//
+//	sup := NewSupervisor()
+//	sup.Add("consumer", consume)
+//	sup.AddContext("poller", poll)
+//	sup.Start()
+//	...
+//	err := sup.Shutdown(ctx)
+type Supervisor struct {
+	opts []Option
+
+	mu      sync.Mutex
+	workers []supervisedWorker
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	stopped bool
+	live    map[string]int
+	paused  map[string]chan struct{}
+
+	// waiting, if set, is called when a paused worker starts to wait for
+	// Resume.  It lets tests know that a worker has reached the pause.
+	waiting func(opName string)
+
+	wg      sync.WaitGroup
+	running int32
+}
+
+// supervisedWorker is a worker registered with a Supervisor.
+type supervisedWorker struct {
+	opName string
+	f      func(context.Context) error
+}
+
+// NewSupervisor returns a Supervisor whose workers are restarted using opts.
+func NewSupervisor(opts ...Option) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		live:   make(map[string]int),
+		paused: make(map[string]chan struct{}),
+	}
+}
+
+// Add registers f as a worker named opName.  If the Supervisor has already been
+// started then f is started immediately.
+func (s *Supervisor) Add(opName string, f Restartable) {
+	s.AddContext(opName, func(context.Context) error {
+		return f()
+	})
+}
+
+// AddContext registers a worker that receives a context which is cancelled when
+// the Supervisor is shut down.  Workers that honor the context can be stopped
+// promptly by Shutdown.
+func (s *Supervisor) AddContext(opName string, f func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := supervisedWorker{opName: opName, f: f}
+	s.workers = append(s.workers, w)
+	if s.started {
+		s.launch(w)
+	}
+}
+
+// Start launches every registered worker.  Calling Start more than once has no
+// effect.
+func (s *Supervisor) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, w := range s.workers {
+		s.launch(w)
+	}
+}
+
+// launch runs w in its own goroutine.  s.mu must be held.
+func (s *Supervisor) launch(w supervisedWorker) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	s.wg.Add(1)
+	atomic.AddInt32(&s.running, 1)
+	s.live[w.opName]++
+
+	go func() {
+		defer s.wg.Done()
+		defer atomic.AddInt32(&s.running, -1)
+		defer s.exited(w.opName)
+
+		// Wait for Resume before each run is timed so that time spent paused is
+		// not mistaken for a long, successful run.
+		opts := append(s.opts[:len(s.opts):len(s.opts)], withBeforeRun(func(ctx context.Context) error {
+			return s.waitResumed(ctx, w.opName)
+		}))
+		WithRestartContext(s.ctx, w.opName, w.f, opts...)
+	}()
+}
+
+// Pause stops the Supervisor from restarting the workers named opName until
+// Resume is called.  A worker that is running when Pause is called is not
+// interrupted and its context is not cancelled; it is allowed to finish its
+// current run, and if that run fails or panics the worker waits to be resumed
+// instead of being restarted.  A worker that returns nil finishes as usual.
+// Paused workers still count towards Running and are stopped by Shutdown.
+//
+// Pause may be called before the worker is added or started, in which case its
+// first run waits for Resume.  Pausing a paused worker has no effect.
+func (s *Supervisor) Pause(opName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.paused[opName]; !ok {
+		s.paused[opName] = make(chan struct{})
+	}
+}
+
+// Resume allows the workers named opName to be restarted again after Pause.  A
+// worker that is waiting to be restarted is started immediately.  Resuming a
+// worker that is not paused has no effect.
+func (s *Supervisor) Resume(opName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch, ok := s.paused[opName]; ok {
+		close(ch)
+		delete(s.paused, opName)
+	}
+}
+
+// waitResumed blocks while the workers named opName are paused or until ctx is
+// done, in which case it returns ctx.Err().
+func (s *Supervisor) waitResumed(ctx context.Context, opName string) error {
+	for {
+		s.mu.Lock()
+		ch, ok := s.paused[opName]
+		s.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		if s.waiting != nil {
+			s.waiting(opName)
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Running returns the number of workers that are currently running or waiting
+// to be restarted.
+func (s *Supervisor) Running() int {
+	return int(atomic.LoadInt32(&s.running))
+}
+
+// exited records that a worker named opName has returned.
+func (s *Supervisor) exited(opName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.live[opName]--; s.live[opName] <= 0 {
+		delete(s.live, opName)
+	}
+}
+
+// Shutdown stops restarting workers and waits for every running worker to
+// return.  If ctx is done before all of the workers have stopped then Shutdown
+// returns an error joining one error per worker that is still running, each of
+// which names the worker and wraps ctx.Err().  The remaining workers are left to
+// finish in the background.
+//
+// Shutdown may be called more than once.  A call made after a previous call has
+// seen every worker stop returns nil immediately.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		return s.lingering(ctx.Err())
+	}
+}
+
+// lingering returns an error for each worker that has not yet returned, wrapping
+// cause.
+func (s *Supervisor) lingering(cause error) error {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.live))
+	for opName := range s.live {
+		names = append(names, opName)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	errs := make([]error, len(names))
+	for i, opName := range names {
+		errs[i] = fmt.Errorf("%s did not stop: %w", opName, cause)
+	}
+	if len(errs) == 0 {
+		// Every worker returned just as ctx expired.
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisorPauseNotCountedAsRuntime(t *testing.T) {
+	defer useLogger(NopLogger{})()
+	clock := newFakeClock()
+	defer useClock(clock)()
+
+	const threshold = 10 * time.Second
+	var mu sync.Mutex
+	var delays []time.Duration
+	sup := NewSupervisor(
+		WithStabilityThreshold(threshold),
+		WithBackoff(NewConstant(1000)),
+		WithOnRetry(func(opName string, attempt int, err error, next time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			delays = append(delays, next)
+		}))
+
+	runs := 0
+	sup.AddContext("paused", func(ctx context.Context) error {
+		runs++
+		if runs > 1 {
+			<-ctx.Done()
+			return nil
+		}
+		return errors.New("failing")
+	})
+
+	waiting := make(chan struct{}, 1)
+	sup.waiting = func(opName string) {
+		select {
+		case waiting <- struct{}{}:
+		default:
+		}
+	}
+
+	sup.Pause("paused")
+	sup.Start()
+	// Only move the clock once the worker is blocked on the pause.
+	<-waiting
+	clock.Advance(2 * threshold)
+	sup.Resume("paused")
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delays) > 0
+	})
+	if err := sup.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if delays[0] != time.Second {
+		t.Errorf("first restart delay after a pause = %s, want the 1s backoff", delays[0])
+	}
+}
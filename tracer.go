@@ -0,0 +1,49 @@
+package recovery
+
+import "context"
+
+// Tracer is used to wrap each attempt made by the retry helpers in a span.  It is
+// an interface so that this package does not depend on a particular tracing
+// library.
+//
+// To trace attempts with OpenTelemetry implement Tracer with an otel trace.Tracer:
+//
+//	type otelTracer struct{ tracer trace.Tracer }
+//
+//	func (t otelTracer) StartAttempt(ctx context.Context, opName string, attempt int) (context.Context, recovery.AttemptSpan) {
+//		ctx, span := t.tracer.Start(ctx, opName, trace.WithAttributes(attribute.Int("retry.attempt", attempt)))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ span trace.Span }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.span.RecordError(err)
+//			s.span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.span.End()
+//	}
+//
+// and pass it to RetryContext with WithTracer(otelTracer{otel.Tracer("myapp")}).
+type Tracer interface {
+	// StartAttempt starts a span for attempt number attempt, counting from 1, of
+	// opName as a child of any span in ctx.  The returned context is passed to
+	// the function being retried.
+	StartAttempt(ctx context.Context, opName string, attempt int) (context.Context, AttemptSpan)
+}
+
+// AttemptSpan is a span started by a Tracer.
+type AttemptSpan interface {
+	// End completes the span.  err is the outcome of the attempt and is nil if
+	// the attempt succeeded.
+	End(err error)
+}
+
+// WithTracer sets the Tracer used to create a span around each attempt made by
+// the UntilSuccessful family, Retry and RetryContext.
+func WithTracer(t Tracer) Option {
+	return func(c *config) {
+		c.tracer = t
+	}
+}